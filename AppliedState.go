@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The replication log and MySQL are separate stores, and a node can stop
+// between writing one and the other. Every node therefore records in MySQL,
+// in the same transaction as the write itself, the LSN and term of the last
+// log entry its databases hold:
+//
+//   - the master appends an entry to the log before it commits the write, so
+//     after a crash MySQL can only be behind the log, and the missing entries
+//     are replayed from the log;
+//   - a slave commits an entry before it appends it to the log, so MySQL can
+//     be one entry ahead; that entry is logged but not applied again when the
+//     master sends it.
+//
+// Schema changes commit implicitly and cannot share a transaction with the
+// state row. The master stores such an entry in the row as pending before it
+// runs the statement and finishes it on restart.
+const stateDatabase = "_replication"
+
+var (
+	stateMu sync.Mutex
+	// replayNeeded is set while MySQL may be behind the log on this node.
+	replayNeeded bool
+	// unloggedLSN and unloggedTerm identify an entry MySQL holds that the log
+	// does not yet, or 0.
+	unloggedLSN, unloggedTerm uint64
+)
+
+var errStateAhead = errors.New("MySQL holds writes that are missing from the replication log")
+
+// appliedState is the state row of this node.
+type appliedState struct {
+	LSN     uint64
+	Term    uint64
+	Pending *LogEntry
+}
+
+func stateTable() string {
+	return quoteIdent(stateDatabase) + "." + quoteIdent("applied")
+}
+
+// isSchemaChange reports whether operation commits implicitly in MySQL.
+func isSchemaChange(operation string) bool {
+	switch operation {
+	case "createdb", "dropdb", "createtable":
+		return true
+	}
+	return false
+}
+
+func readAppliedState() (appliedState, bool, error) {
+	var (
+		state   appliedState
+		pending sql.NullString
+	)
+	err := db.QueryRow("SELECT lsn, term, pending FROM "+stateTable()+" WHERE id = 1").Scan(&state.LSN, &state.Term, &pending)
+	if err == sql.ErrNoRows {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if pending.Valid {
+		var entry LogEntry
+		if err := decodeJSON(strings.NewReader(pending.String), &entry); err != nil {
+			return state, true, fmt.Errorf("decoding pending entry: %w", err)
+		}
+		state.Pending = &entry
+	}
+	return state, true, nil
+}
+
+// recordApplied stores lsn and term as the last entry MySQL holds and clears
+// any pending entry.
+func recordApplied(ex execer, lsn, term uint64) error {
+	_, err := ex.ExecContext(context.Background(), "INSERT INTO "+stateTable()+
+		" (id, lsn, term, pending) VALUES (1, ?, ?, NULL) ON DUPLICATE KEY UPDATE lsn = VALUES(lsn), term = VALUES(term), pending = NULL", lsn, term)
+	if err != nil {
+		countMySQLError("state")
+	}
+	return err
+}
+
+func recordPending(entry *LogEntry) error {
+	var pending interface{}
+	if entry != nil {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		pending = string(data)
+	}
+	_, err := db.Exec("UPDATE "+stateTable()+" SET pending = ? WHERE id = 1", pending)
+	if err != nil {
+		countMySQLError("state")
+	}
+	return err
+}
+
+// applyLogged applies entry to MySQL and records it as applied, in one
+// transaction unless it is a schema change. Schema changes are idempotent,
+// so applying one twice is harmless.
+func applyLogged(entry LogEntry) error {
+	switch {
+	case entry.Task.Operation == "skip":
+		return recordApplied(db, entry.LSN, entry.Term)
+	case isSchemaChange(entry.Task.Operation):
+		if _, err := applyTask(db, entry.Task); err != nil {
+			return err
+		}
+		return recordApplied(db, entry.LSN, entry.Term)
+	}
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		countMySQLError(entry.Task.Operation)
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := applyTask(tx, entry.Task); err != nil {
+		return err
+	}
+	if err := recordApplied(tx, entry.LSN, entry.Term); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		countMySQLError(entry.Task.Operation)
+		return err
+	}
+	return nil
+}
+
+// recoverAppliedState creates the state table and brings MySQL in line with
+// the replication log after a restart. A node that cannot replay keeps
+// running; the master refuses writes until the replay succeeds.
+func recoverAppliedState() error {
+	if _, err := db.Exec("CREATE DATABASE IF NOT EXISTS " + quoteIdent(stateDatabase)); err != nil {
+		return err
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + stateTable() + ` (
+		id TINYINT UNSIGNED PRIMARY KEY,
+		lsn BIGINT UNSIGNED NOT NULL,
+		term BIGINT UNSIGNED NOT NULL,
+		pending LONGTEXT NULL
+	)`); err != nil {
+		return err
+	}
+	if _, found, err := readAppliedState(); err != nil {
+		return err
+	} else if !found {
+		// The first start with a state table: MySQL holds what the log holds.
+		return recordApplied(db, replicationLog.LastLSN(), replicationLog.LastTerm())
+	}
+
+	stateMu.Lock()
+	replayNeeded = true
+	stateMu.Unlock()
+	if err := replayLog(); err != nil {
+		slog.Error("MySQL does not match the replication log", "error", err)
+	}
+	return nil
+}
+
+// replayLog applies the log entries MySQL is missing. The caller holds
+// writeMu, or no other writes run yet.
+func replayLog() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if !replayNeeded {
+		return nil
+	}
+
+	state, _, err := readAppliedState()
+	if err != nil {
+		return err
+	}
+	if state.Pending != nil {
+		if err := finishPending(*state.Pending); err != nil {
+			return err
+		}
+		if state, _, err = readAppliedState(); err != nil {
+			return err
+		}
+	}
+
+	last := replicationLog.LastLSN()
+	switch {
+	case state.LSN == last+1:
+		// A slave stopped after applying an entry and before logging it.
+		unloggedLSN, unloggedTerm = state.LSN, state.Term
+		replayNeeded = false
+		slog.Info("MySQL is one entry ahead of the replication log", "lsn", state.LSN, "term", state.Term)
+		return nil
+	case state.LSN > last || state.LSN < replicationLog.Base():
+		if err := os.WriteFile(snapshotPendingPath(), nil, 0644); err != nil {
+			return err
+		}
+		return fmt.Errorf("MySQL is at LSN %d but the replication log holds %d to %d: %w", state.LSN, replicationLog.Base(), last, errStateAhead)
+	}
+
+	replayed := 0
+	for state.LSN < last {
+		entries, err := replicationLog.Entries(state.LSN, 100, entryBatchBytes)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := applyLogged(entry); err != nil {
+				return fmt.Errorf("replaying LSN %d: %w", entry.LSN, err)
+			}
+			state.LSN = entry.LSN
+			replayed++
+		}
+	}
+	if replayed > 0 {
+		slog.Warn("Replayed log entries MySQL was missing", "entries", replayed, "lsn", last)
+	}
+	replayNeeded = false
+	return nil
+}
+
+// finishPending completes a schema change the master stopped in the middle
+// of. If the statement fails now it failed before and was never logged.
+func finishPending(entry LogEntry) error {
+	if entry.LSN != replicationLog.LastLSN()+1 {
+		return recordPending(nil)
+	}
+	if _, err := applyTask(db, entry.Task); err != nil {
+		slog.Warn("Discarding unfinished schema change", append(taskAttrs(entry.LSN, entry.Task), "error", err)...)
+		return recordPending(nil)
+	}
+	if err := replicationLog.AppendEntry(entry); err != nil {
+		return err
+	}
+	slog.Warn("Finished schema change interrupted by a restart", taskAttrs(entry.LSN, entry.Task)...)
+	return recordApplied(db, entry.LSN, entry.Term)
+}
+
+// markReplayNeeded notes that MySQL may be behind the log after a failure.
+func markReplayNeeded() {
+	stateMu.Lock()
+	replayNeeded = true
+	stateMu.Unlock()
+}
+
+// mysqlAhead reports whether MySQL holds an entry the log is still missing.
+// Such a node must get the entry from the master and does not stand for
+// election.
+func mysqlAhead() bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return unloggedLSN != 0
+}
+
+// takeUnlogged reports whether entry is the one MySQL already holds. It
+// returns an error if the master's entry at that LSN is a different one.
+func takeUnlogged(entry LogEntry) (bool, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if unloggedLSN == 0 || entry.LSN != unloggedLSN {
+		return false, nil
+	}
+	term := unloggedTerm
+	unloggedLSN, unloggedTerm = 0, 0
+	if entry.Term != term {
+		return false, diverged(entry.LSN, term, entry.Term)
+	}
+	return true, nil
+}
+
+// resetAppliedState records that MySQL holds a snapshot taken at lsn.
+func resetAppliedState(lsn uint64) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	unloggedLSN, unloggedTerm = 0, 0
+	replayNeeded = false
+	return recordApplied(db, lsn, 0)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// nodeTokenHeader carries the shared secret nodes use to call each other.
+const nodeTokenHeader = "X-Node-Token"
+
+// Role is what an API key may do in a database. Every role includes the
+// ones before it: read-only reads, writer also inserts, updates and deletes,
+// and admin also creates and drops databases and tables.
+type Role int
+
+const (
+	roleNone Role = iota
+	roleReadOnly
+	roleWriter
+	roleAdmin
+)
+
+var roleNames = map[string]Role{
+	"read-only": roleReadOnly,
+	"writer":    roleWriter,
+	"admin":     roleAdmin,
+}
+
+func (r Role) String() string {
+	for name, role := range roleNames {
+		if role == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// APIKey is a client credential, sent as "Authorization: Bearer <key>".
+// Roles maps a database name, or "*" for every database and the cluster as a
+// whole, to a role; a database's own entry takes precedence over "*".
+type APIKey struct {
+	Name  string            `json:"name"`
+	Key   string            `json:"key"`
+	Roles map[string]string `json:"roles"`
+}
+
+// AuthConfig protects the HTTP API. NodeToken is the shared secret every
+// node sends to the others in the X-Node-Token header; only requests that
+// carry it may replicate, register slaves, vote or read the log and
+// snapshots. With APIKeys set, every client request needs a key whose role
+// covers the database it touches. Both are off when empty, which is only
+// meant for a trusted network.
+type AuthConfig struct {
+	NodeToken string   `json:"node_token"`
+	APIKeys   []APIKey `json:"api_keys"`
+}
+
+func (a AuthConfig) validate() error {
+	if len(a.APIKeys) > 0 && a.NodeToken == "" {
+		return fmt.Errorf("node_token is required when api_keys are set")
+	}
+	names := map[string]bool{}
+	keys := map[string]bool{}
+	for _, k := range a.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("every api key needs a name and a key")
+		}
+		if names[k.Name] || keys[k.Key] {
+			return fmt.Errorf("api key %q is not unique", k.Name)
+		}
+		if k.Key == a.NodeToken {
+			return fmt.Errorf("api key %q is the same as the node token", k.Name)
+		}
+		names[k.Name], keys[k.Key] = true, true
+		for dbname, role := range k.Roles {
+			if dbname != "*" {
+				if err := checkIdent("database", dbname); err != nil {
+					return fmt.Errorf("api key %q: %w", k.Name, err)
+				}
+			}
+			if _, ok := roleNames[role]; !ok {
+				return fmt.Errorf("api key %q: invalid role %q for %s: must be read-only, writer or admin", k.Name, role, dbname)
+			}
+		}
+	}
+	return nil
+}
+
+// principal is the caller of a request: another node, the holder of an API
+// key, or anonymous.
+type principal struct {
+	name  string
+	node  bool
+	roles map[string]Role
+}
+
+// role returns the caller's role in dbname, or in the cluster for "*".
+func (p *principal) role(dbname string) Role {
+	if p.node {
+		return roleAdmin
+	}
+	if r, ok := p.roles[dbname]; ok {
+		return r
+	}
+	return p.roles["*"]
+}
+
+func (p *principal) anonymous() bool {
+	return !p.node && p.name == ""
+}
+
+type principalKey struct{}
+
+// authenticate identifies the caller. Requests without credentials are
+// anonymous; wrong credentials are an error. Another node is recognized by
+// the node token and, with mutual TLS, by its certificate; when both are
+// configured it needs both.
+func authenticate(r *http.Request) (*principal, error) {
+	if token := r.Header.Get(nodeTokenHeader); token != "" {
+		if cfg.Auth.NodeToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Auth.NodeToken)) != 1 {
+			return nil, fmt.Errorf("invalid node token")
+		}
+		if cfg.TLS.mutual() && !verifiedPeer(r) {
+			return nil, fmt.Errorf("requests between nodes need a certificate signed by the cluster CA")
+		}
+		return &principal{name: "node", node: true}, nil
+	}
+	if cfg.Auth.NodeToken == "" && verifiedPeer(r) {
+		return &principal{name: "node", node: true}, nil
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return &principal{}, nil
+	}
+	key, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("expected an Authorization header of the form Bearer <key>")
+	}
+	var found *APIKey
+	for i := range cfg.Auth.APIKeys {
+		// Compare against every key so the time taken does not reveal which
+		// one matched.
+		if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.Auth.APIKeys[i].Key)) == 1 {
+			found = &cfg.Auth.APIKeys[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	p := &principal{name: found.Name, roles: map[string]Role{}}
+	for dbname, role := range found.Roles {
+		p.roles[dbname] = roleNames[role]
+	}
+	return p, nil
+}
+
+// withAuth authenticates every request and makes the caller available to
+// the handlers through callerOf. Which caller may do what is decided by the
+// handlers.
+func withAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error(), "code": "unauthenticated"})
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+func callerOf(r *http.Request) *principal {
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok {
+		return p
+	}
+	return &principal{}
+}
+
+// authorized reports whether the caller holds at least role in dbname, or
+// in the whole cluster for "*". If not, it answers 401 or 403.
+func authorized(w http.ResponseWriter, r *http.Request, dbname string, role Role) bool {
+	if len(cfg.Auth.APIKeys) == 0 {
+		return true
+	}
+	p := callerOf(r)
+	if p.role(dbname) >= role {
+		return true
+	}
+	what := "database " + dbname
+	if dbname == "*" {
+		what = "the cluster"
+	}
+	denied(w, p, fmt.Sprintf("%s role required on %s", role, what))
+	return false
+}
+
+func denied(w http.ResponseWriter, p *principal, message string) {
+	if p.anonymous() {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "authentication required: " + message, "code": "unauthenticated"})
+		return
+	}
+	writeJSON(w, http.StatusForbidden, map[string]string{"error": p.name + " is not allowed: " + message, "code": "forbidden"})
+}
+
+// requireRole serves h only to callers with role in the whole cluster.
+func requireRole(role Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r, "*", role) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// nodeAuthEnabled reports whether nodes identify themselves, with the node
+// token or a client certificate. Without either every caller is trusted as a
+// node.
+func nodeAuthEnabled() bool {
+	return cfg.Auth.NodeToken != "" || cfg.TLS.mutual()
+}
+
+// fromNode reports whether r was sent by another node of the cluster.
+func fromNode(r *http.Request) bool {
+	return !nodeAuthEnabled() || callerOf(r).node
+}
+
+// nodeOnly serves h only to other nodes of the cluster.
+func nodeOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !fromNode(r) {
+			denied(w, callerOf(r), "only cluster nodes may call "+r.URL.Path)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requiredRole is the role a write operation needs in its database.
+func requiredRole(operation string) Role {
+	switch operation {
+	case "createdb", "dropdb", "createtable":
+		return roleAdmin
+	}
+	return roleWriter
+}
+
+// authorizedTask checks the caller's role in every database task writes to.
+func authorizedTask(w http.ResponseWriter, r *http.Request, task ReplicationTask) bool {
+	if task.Operation != "transaction" {
+		return authorized(w, r, task.DBName, requiredRole(task.Operation))
+	}
+	for _, op := range task.Operations {
+		if !authorized(w, r, op.DBName, requiredRole(op.Operation)) {
+			return false
+		}
+	}
+	return true
+}
+
+// readableDatabases filters names down to the databases the caller may read.
+func readableDatabases(r *http.Request, names []string) []string {
+	if len(cfg.Auth.APIKeys) == 0 {
+		return names
+	}
+	p := callerOf(r)
+	readable := []string{}
+	for _, name := range names {
+		if p.role(name) >= roleReadOnly {
+			readable = append(readable, name)
+		}
+	}
+	return readable
+}
+
+// nodeTransport identifies this node in every request to another node, with
+// the node token and, with mutual TLS, its certificate.
+type nodeTransport struct{}
+
+func (nodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if cfg.Auth.NodeToken != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(nodeTokenHeader, cfg.Auth.NodeToken)
+	}
+	node, _ := currentTransports()
+	return node.RoundTrip(req)
+}
+
+// nodeClient returns a client for requests to other nodes of the cluster.
+// A timeout of 0 means none.
+func nodeClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: nodeTransport{}}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withAuthConfig sets the auth config for the duration of a test.
+func withAuthConfig(t *testing.T, auth AuthConfig) {
+	t.Helper()
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.Auth = auth
+	cfg.TLS = TLSConfig{}
+}
+
+var testAuth = AuthConfig{
+	NodeToken: "node-secret",
+	APIKeys: []APIKey{
+		{Name: "reader", Key: "r-key", Roles: map[string]string{"*": "read-only"}},
+		{Name: "shop-writer", Key: "w-key", Roles: map[string]string{"shop": "writer", "*": "read-only"}},
+		{Name: "ops", Key: "a-key", Roles: map[string]string{"*": "admin", "secret": "read-only"}},
+	},
+}
+
+func TestAuthenticate(t *testing.T) {
+	withAuthConfig(t, testAuth)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		caller  string
+		node    bool
+		err     bool
+	}{
+		{"anonymous", nil, "", false, false},
+		{"api key", map[string]string{"Authorization": "Bearer w-key"}, "shop-writer", false, false},
+		{"node token", map[string]string{nodeTokenHeader: "node-secret"}, "node", true, false},
+		{"wrong node token", map[string]string{nodeTokenHeader: "guess"}, "", false, true},
+		{"api key as node token", map[string]string{nodeTokenHeader: "a-key"}, "", false, true},
+		{"node token as api key", map[string]string{"Authorization": "Bearer node-secret"}, "", false, true},
+		{"unknown key", map[string]string{"Authorization": "Bearer nope"}, "", false, true},
+		{"not bearer", map[string]string{"Authorization": "Basic cjpr"}, "", false, true},
+		{"key prefix", map[string]string{"Authorization": "Bearer w-ke"}, "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/select", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			p, err := authenticate(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if p.name != tt.caller || p.node != tt.node {
+				t.Errorf("caller = %q node %v, want %q node %v", p.name, p.node, tt.caller, tt.node)
+			}
+		})
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	withAuthConfig(t, testAuth)
+
+	tests := []struct {
+		name   string
+		key    string
+		dbname string
+		role   Role
+		status int
+	}{
+		{"reader reads", "r-key", "shop", roleReadOnly, http.StatusOK},
+		{"reader writes", "r-key", "shop", roleWriter, http.StatusForbidden},
+		{"writer writes own database", "w-key", "shop", roleWriter, http.StatusOK},
+		{"writer writes other database", "w-key", "crm", roleWriter, http.StatusForbidden},
+		{"writer creates table", "w-key", "shop", roleAdmin, http.StatusForbidden},
+		{"admin creates table", "a-key", "shop", roleAdmin, http.StatusOK},
+		{"database entry wins over star", "a-key", "secret", roleWriter, http.StatusForbidden},
+		{"admin on the cluster", "a-key", "*", roleAdmin, http.StatusOK},
+		{"writer on the cluster", "w-key", "*", roleAdmin, http.StatusForbidden},
+		{"anonymous", "", "shop", roleReadOnly, http.StatusUnauthorized},
+		{"node", "node", "shop", roleAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/select", nil)
+			switch tt.key {
+			case "":
+			case "node":
+				r.Header.Set(nodeTokenHeader, testAuth.NodeToken)
+			default:
+				r.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if authorized(w, r, tt.dbname, tt.role) {
+					w.WriteHeader(http.StatusOK)
+				}
+			})).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthorizedTask(t *testing.T) {
+	withAuthConfig(t, testAuth)
+	writer := &principal{name: "shop-writer", roles: map[string]Role{"shop": roleWriter, "*": roleReadOnly}}
+
+	tests := []struct {
+		name string
+		task ReplicationTask
+		ok   bool
+	}{
+		{"insert", ReplicationTask{Operation: "insert", DBName: "shop"}, true},
+		{"createtable", ReplicationTask{Operation: "createtable", DBName: "shop"}, false},
+		{"dropdb", ReplicationTask{Operation: "dropdb", DBName: "shop"}, false},
+		{"transaction", ReplicationTask{Operation: "transaction", Operations: []ReplicationTask{
+			{Operation: "insert", DBName: "shop"}, {Operation: "delete", DBName: "shop"},
+		}}, true},
+		{"transaction into another database", ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{
+			{Operation: "insert", DBName: "shop"}, {Operation: "delete", DBName: "crm"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/insert", nil)
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, writer))
+			if got := authorizedTask(httptest.NewRecorder(), r, tt.task); got != tt.ok {
+				t.Errorf("authorizedTask = %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNodeOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   AuthConfig
+		header map[string]string
+		status int
+	}{
+		{"no node auth", AuthConfig{}, nil, http.StatusOK},
+		{"node token", testAuth, map[string]string{nodeTokenHeader: "node-secret"}, http.StatusOK},
+		{"anonymous", testAuth, nil, http.StatusUnauthorized},
+		{"admin key", testAuth, map[string]string{"Authorization": "Bearer a-key"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withAuthConfig(t, tt.auth)
+			r := httptest.NewRequest(http.MethodPost, "/election/vote", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			withAuth(nodeOnly(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		auth AuthConfig
+		ok   bool
+	}{
+		{"off", AuthConfig{}, true},
+		{"valid", testAuth, true},
+		{"keys without node token", AuthConfig{APIKeys: testAuth.APIKeys}, false},
+		{"duplicate key", AuthConfig{NodeToken: "n", APIKeys: []APIKey{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}}, false},
+		{"key equals node token", AuthConfig{NodeToken: "k", APIKeys: []APIKey{{Name: "a", Key: "k"}}}, false},
+		{"unknown role", AuthConfig{NodeToken: "n", APIKeys: []APIKey{{Name: "a", Key: "k", Roles: map[string]string{"*": "root"}}}}, false},
+		{"invalid database", AuthConfig{NodeToken: "n", APIKeys: []APIKey{{Name: "a", Key: "k", Roles: map[string]string{"a;b": "admin"}}}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.auth.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
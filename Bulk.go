@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxBulkRows is the most rows one bulk insert may carry and maxBulkBytes
+// the largest request body it accepts.
+const (
+	maxBulkRows  = 50000
+	maxBulkBytes = 16 << 20
+)
+
+// buildBulkInsert turns a bulk insert into multi-row INSERT statements.
+func buildBulkInsert(task ReplicationTask) ([]statement, error) {
+	if err := checkIdent("database", task.DBName); err != nil {
+		return nil, err
+	}
+	if err := checkIdent("table", task.Table); err != nil {
+		return nil, err
+	}
+	if len(task.Rows) == 0 {
+		return nil, invalidf("rows are required")
+	}
+	if len(task.Rows) > maxBulkRows {
+		return nil, invalidf("a bulk insert holds at most %d rows", maxBulkRows)
+	}
+	return buildRows(quoteIdent(task.DBName)+"."+quoteIdent(task.Table), task.Rows)
+}
+
+// isNDJSON reports whether the request body is newline-delimited JSON.
+func isNDJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return true
+	}
+	return r.URL.Query().Get("format") == "ndjson"
+}
+
+// decodeBulkInsert reads a bulk insert. The body is either an object with
+// dbname, table, rows and optionally write_concern, a JSON array of rows, or
+// newline-delimited JSON with one row per line. The last two take dbname,
+// table and the write concern level from the query.
+func decodeBulkInsert(r *http.Request) (ReplicationTask, *WriteConcern, error) {
+	task := ReplicationTask{
+		Operation: "bulkinsert",
+		DBName:    r.URL.Query().Get("dbname"),
+		Table:     r.URL.Query().Get("table"),
+	}
+	var wc *WriteConcern
+	if level := r.URL.Query().Get("write_concern"); level != "" {
+		wc = &WriteConcern{Level: level}
+	}
+
+	body := bufio.NewReader(r.Body)
+	if isNDJSON(r) {
+		decoder := json.NewDecoder(body)
+		decoder.UseNumber()
+		for {
+			var row map[string]interface{}
+			err := decoder.Decode(&row)
+			if err == io.EOF {
+				return task, wc, nil
+			}
+			if err != nil {
+				return task, wc, fmt.Errorf("row %d: %w", len(task.Rows)+1, err)
+			}
+			if len(task.Rows) == maxBulkRows {
+				return task, wc, invalidf("a bulk insert holds at most %d rows", maxBulkRows)
+			}
+			task.Rows = append(task.Rows, row)
+		}
+	}
+
+	first, err := firstByte(body)
+	if err != nil {
+		return task, wc, err
+	}
+	if first == '[' {
+		return task, wc, decodeJSON(body, &task.Rows)
+	}
+	var req struct {
+		DBName       string                   `json:"dbname"`
+		Table        string                   `json:"table"`
+		Rows         []map[string]interface{} `json:"rows"`
+		WriteConcern *WriteConcern            `json:"write_concern"`
+	}
+	if err := decodeJSON(body, &req); err != nil {
+		return task, wc, err
+	}
+	if req.DBName != "" {
+		task.DBName = req.DBName
+	}
+	if req.Table != "" {
+		task.Table = req.Table
+	}
+	if req.WriteConcern != nil {
+		wc = req.WriteConcern
+	}
+	task.Rows = req.Rows
+	return task, wc, nil
+}
+
+// firstByte returns the first byte of r that is not white space, without
+// consuming it.
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// bulkInsert serves /bulkinsert: many rows are inserted in one transaction
+// and replicated as a single log entry.
+func bulkInsert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Use POST", nil)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBulkBytes)
+	task, wc, err := decodeBulkInsert(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("request body is larger than %d bytes; split the rows into several requests", maxBulkBytes),
+			"code":  "too_large",
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	commitRequest(w, r, task, wc, fmt.Sprintf("%d rows inserted successfully", len(task.Rows)))
+}
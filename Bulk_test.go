@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBulkInsert(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		table       string
+		rows        string
+		level       string
+	}{
+		{"object", "", "application/json", `{"dbname":"shop","table":"orders","rows":[{"id":1},{"id":2}],"write_concern":{"level":"majority"}}`,
+			"shop.orders", "[map[id:1] map[id:2]]", "majority"},
+		{"object overrides the query", "?dbname=crm&table=users&write_concern=all", "application/json", `{"dbname":"shop","table":"orders","rows":[{"id":1}]}`,
+			"shop.orders", "[map[id:1]]", "all"},
+		{"array", "?dbname=shop&table=orders&write_concern=majority", "application/json", " \n[{\"id\":1},{\"id\":2}]",
+			"shop.orders", "[map[id:1] map[id:2]]", "majority"},
+		{"ndjson", "?dbname=shop&table=orders", "application/x-ndjson", "{\"id\":1}\n{\"id\":2}\n\n{\"id\":3}",
+			"shop.orders", "[map[id:1] map[id:2] map[id:3]]", ""},
+		{"ndjson from the query", "?dbname=shop&table=orders&format=ndjson", "", "{\"id\":1}\n",
+			"shop.orders", "[map[id:1]]", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/bulkinsert"+tt.query, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			task, wc, err := decodeBulkInsert(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table := task.DBName + "." + task.Table; table != tt.table {
+				t.Errorf("table = %s, want %s", table, tt.table)
+			}
+			if rows := fmt.Sprint(task.Rows); rows != tt.rows {
+				t.Errorf("rows = %s, want %s", rows, tt.rows)
+			}
+			level := ""
+			if wc != nil {
+				level = wc.Level
+			}
+			if level != tt.level {
+				t.Errorf("write concern = %q, want %q", level, tt.level)
+			}
+		})
+	}
+}
+
+func TestDecodeBulkInsertErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		err         string
+	}{
+		{"empty body", "application/json", "", "EOF"},
+		{"broken array", "application/json", `[{"id":1},`, "EOF"},
+		{"broken ndjson line", "application/x-ndjson", "{\"id\":1}\n{\"id\":\n", "row 2"},
+		{"too many ndjson rows", "application/x-ndjson", strings.Repeat("{\"id\":1}\n", maxBulkRows+1), "at most 50000 rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/bulkinsert?dbname=shop&table=orders", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if _, _, err := decodeBulkInsert(r); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBuildBulkInsert(t *testing.T) {
+	rows := func(n int) []map[string]interface{} {
+		var rows []map[string]interface{}
+		for i := 0; i < n; i++ {
+			rows = append(rows, map[string]interface{}{"id": i, "item": "pen"})
+		}
+		return rows
+	}
+
+	stmts, err := buildBulkInsert(ReplicationTask{DBName: "shop", Table: "orders", Rows: rows(insertBatchSize + 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 || len(stmts[0].args) != 2*insertBatchSize || len(stmts[1].args) != 2 {
+		t.Errorf("got %d statements, want one full batch and one row", len(stmts))
+	}
+
+	tests := []struct {
+		name string
+		task ReplicationTask
+	}{
+		{"no rows", ReplicationTask{DBName: "shop", Table: "orders"}},
+		{"too many rows", ReplicationTask{DBName: "shop", Table: "orders", Rows: rows(maxBulkRows + 1)}},
+		{"invalid table", ReplicationTask{DBName: "shop", Table: "a`b", Rows: rows(1)}},
+		{"different columns", ReplicationTask{DBName: "shop", Table: "orders", Rows: append(rows(1), map[string]interface{}{"id": 2})}},
+	}
+	for _, tt := range tests {
+		var verr *validationError
+		if _, err := buildBulkInsert(tt.task); !errors.As(err, &verr) {
+			t.Errorf("%s: err = %v, want a validation error", tt.name, err)
+		}
+	}
+}
+
+func TestBulkInsertBodyTooLarge(t *testing.T) {
+	body := "[" + strings.Repeat(`{"item":"pen"},`, maxBulkBytes/15+1) + `{"item":"pen"}]`
+	w := httptest.NewRecorder()
+	bulkInsert(w, httptest.NewRequest(http.MethodPost, "/bulkinsert?dbname=shop&table=orders", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "too_large") {
+		t.Errorf("got %d %s, want 413 too_large", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig says which browser origins may call the API. CORS is off while
+// AllowedOrigins is empty. "*" allows every origin but cannot be combined
+// with AllowCredentials. Endpoints only other nodes call never answer CORS.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds"`
+}
+
+// exposedHeaders are the response headers browsers may read.
+var exposedHeaders = []string{tokenHeader, appliedLSNHeader, requestIDHeader}
+
+func defaultCORS() CORSConfig {
+	return CORSConfig{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+		AllowedHeaders: []string{"Content-Type", "Authorization", tokenHeader, requestIDHeader},
+		MaxAgeSeconds:  600,
+	}
+}
+
+func (c CORSConfig) validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return fmt.Errorf(`allowed_origins "*" cannot be combined with allow_credentials`)
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") || strings.HasSuffix(origin, "/") {
+			return fmt.Errorf("invalid origin %q: must be a scheme and host such as https://app.example.com", origin)
+		}
+	}
+	if c.MaxAgeSeconds < 0 {
+		return fmt.Errorf("max_age_seconds must not be negative")
+	}
+	return nil
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if origin is not allowed.
+func (c CORSConfig) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// internalRoutes are the patterns registered with internalRoute.
+var internalRoutes = map[string]bool{}
+
+// internalRoute registers an endpoint only other nodes call. It is served
+// to nodes only and never answers CORS.
+func internalRoute(pattern string, h http.HandlerFunc) {
+	internalRoutes[pattern] = true
+	http.HandleFunc(pattern, nodeOnly(h))
+}
+
+// withCORS applies the CORS policy to every request and answers OPTIONS
+// requests, including CORS preflights, itself. Preflights carry no
+// credentials, so they are answered before authentication.
+func withCORS(mux *http.ServeMux, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		internal := internalRoutes[pattern]
+		origin := r.Header.Get("Origin")
+
+		allowed := ""
+		if origin != "" && !internal {
+			allowed = cfg.CORS.allowOrigin(origin)
+		}
+		if len(cfg.CORS.AllowedOrigins) > 0 && !internal {
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			if cfg.CORS.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		if r.Method != http.MethodOptions {
+			if allowed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		preflight := origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight && allowed == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin " + origin + " is not allowed", "code": "cors_forbidden"})
+			return
+		}
+		methods := strings.Join(append([]string{http.MethodOptions}, cfg.CORS.AllowedMethods...), ", ")
+		w.Header().Set("Allow", methods)
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.CORS.AllowedHeaders, ", "))
+			if cfg.CORS.MaxAgeSeconds > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.CORS.MaxAgeSeconds))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
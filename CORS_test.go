@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// corsServer is a mux with one public and one internal route behind
+// withCORS, the way main wires them up.
+func corsServer(t *testing.T, c CORSConfig) http.Handler {
+	t.Helper()
+	saved := cfg
+	t.Cleanup(func() {
+		cfg = saved
+		delete(internalRoutes, "/test/internal")
+	})
+	cfg.CORS = c
+
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	mux.HandleFunc("/test/public", ok)
+	mux.HandleFunc("/test/internal", ok)
+	internalRoutes["/test/internal"] = true
+	return withCORS(mux, mux)
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := defaultCORS()
+	c.AllowedOrigins = []string{"https://app.example.com"}
+	c.AllowCredentials = true
+	h := corsServer(t, c)
+
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		status      int
+		allowOrigin string
+		methods     string
+	}{
+		{"allowed origin", "/test/public", "https://app.example.com", http.StatusNoContent, "https://app.example.com", "OPTIONS, GET, POST, DELETE"},
+		{"origin case", "/test/public", "https://APP.example.com", http.StatusNoContent, "https://APP.example.com", "OPTIONS, GET, POST, DELETE"},
+		{"other origin", "/test/public", "https://evil.example.com", http.StatusForbidden, "", ""},
+		{"internal route", "/test/internal", "https://app.example.com", http.StatusForbidden, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			r.Header.Set("Origin", tt.origin)
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			header := w.Header()
+			if w.Code != tt.status || header.Get("Access-Control-Allow-Origin") != tt.allowOrigin || header.Get("Access-Control-Allow-Methods") != tt.methods {
+				t.Errorf("got %d origin %q methods %q, want %d %q %q", w.Code, header.Get("Access-Control-Allow-Origin"), header.Get("Access-Control-Allow-Methods"), tt.status, tt.allowOrigin, tt.methods)
+			}
+			if w.Body.String() == "ok" {
+				t.Error("the preflight reached the handler")
+			}
+			if tt.allowOrigin != "" {
+				if got := header.Get("Access-Control-Allow-Credentials"); got != "true" {
+					t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+				}
+				if got := header.Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	c := defaultCORS()
+	c.AllowedOrigins = []string{"*"}
+	h := corsServer(t, c)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		status      int
+		allowOrigin string
+		vary        bool
+	}{
+		{"public route", http.MethodGet, "/test/public", "https://app.example.com", http.StatusOK, "*", true},
+		{"no origin", http.MethodGet, "/test/public", "", http.StatusOK, "", true},
+		{"internal route", http.MethodPost, "/test/internal", "https://app.example.com", http.StatusOK, "", false},
+		{"plain OPTIONS", http.MethodOptions, "/test/public", "", http.StatusNoContent, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			header := w.Header()
+			if w.Code != tt.status || header.Get("Access-Control-Allow-Origin") != tt.allowOrigin {
+				t.Errorf("got %d origin %q, want %d %q", w.Code, header.Get("Access-Control-Allow-Origin"), tt.status, tt.allowOrigin)
+			}
+			if vary := header.Get("Vary") == "Origin"; vary != tt.vary {
+				t.Errorf("Vary = %q, want Origin %v", header.Get("Vary"), tt.vary)
+			}
+			if got := header.Get("Access-Control-Expose-Headers"); (got != "") != (tt.allowOrigin != "" && tt.method != http.MethodOptions) {
+				t.Errorf("Access-Control-Expose-Headers = %q", got)
+			}
+			if header.Get("Access-Control-Allow-Credentials") != "" {
+				t.Error(`"*" must not allow credentials`)
+			}
+		})
+	}
+}
+
+func TestCORSOff(t *testing.T) {
+	h := corsServer(t, defaultCORS())
+	r := httptest.NewRequest(http.MethodGet, "/test/public", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("with CORS off got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCORSConfigValidate(t *testing.T) {
+	tests := []struct {
+		c  CORSConfig
+		ok bool
+	}{
+		{CORSConfig{AllowedOrigins: []string{"https://app.example.com", "http://localhost:3000"}, AllowCredentials: true}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}}, true},
+		{CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}, false},
+		{CORSConfig{AllowedOrigins: []string{"app.example.com"}}, false},
+		{CORSConfig{AllowedOrigins: []string{"https://app.example.com/"}}, false},
+		{CORSConfig{MaxAgeSeconds: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.c.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, want ok %v", tt.c, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The consistency checker compares every table between the master and its
+// slaves. The master splits a table into chunks of consecutive primary key
+// values and every node checksums the rows of each chunk. Chunks that differ
+// are checked once more at a fence LSN: the master opens a consistent view of
+// its databases at its current LSN, pausing writes only for that moment, and
+// the slave checksums the chunk in a view of its own once it has applied the
+// fence. If the slave is past the fence by then and the entries in between
+// touch the table, the comparison is repeated, so writes still in flight are
+// not reported as drift. A repair replaces the chunk's rows with the master's
+// through the replication log, like any other write.
+
+const (
+	defaultChunkSize   = 1000
+	checksumAckWait    = 10 * time.Second
+	checksumRPCTimeout = 5 * time.Minute
+	// checksumLockTimeout bounds each step that runs while writes are
+	// paused.
+	checksumLockTimeout = 5 * time.Second
+	checksumAttempts    = 3
+	// maxUnkeyedRows is the largest table without a single-column primary
+	// key that is checked; such a table is a single chunk.
+	maxUnkeyedRows = 10000
+)
+
+// querier is the database, or a connection holding a consistent view.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ChunkRange is a range of primary key values, Lower < key <= Upper. A nil
+// bound is open.
+type ChunkRange struct {
+	Lower interface{} `json:"lower,omitempty"`
+	Upper interface{} `json:"upper,omitempty"`
+}
+
+// ChecksumRequest asks a node for the checksums of chunks of one table. Key is
+// the table's primary key column; without one the only range is the whole
+// table.
+type ChecksumRequest struct {
+	DBName string       `json:"dbname"`
+	Table  string       `json:"table"`
+	Key    string       `json:"key,omitempty"`
+	Ranges []ChunkRange `json:"ranges"`
+}
+
+// ChunkChecksum is the checksum of the rows of one chunk.
+type ChunkChecksum struct {
+	Rows     int    `json:"rows"`
+	Checksum string `json:"checksum"`
+}
+
+// CheckOptions selects what the consistency checker compares. Empty DBName
+// and Table check every table, and empty Slaves every online slave.
+type CheckOptions struct {
+	DBName    string   `json:"dbname,omitempty"`
+	Table     string   `json:"table,omitempty"`
+	ChunkSize int      `json:"chunk_size,omitempty"`
+	Slaves    []string `json:"slaves,omitempty"`
+	Repair    bool     `json:"repair,omitempty"`
+}
+
+// ChunkDiff is a chunk whose rows differ between the master and a slave.
+type ChunkDiff struct {
+	Index      int         `json:"index"`
+	Lower      interface{} `json:"lower,omitempty"`
+	Upper      interface{} `json:"upper,omitempty"`
+	MasterRows int         `json:"master_rows"`
+	SlaveRows  int         `json:"slave_rows"`
+	RepairLSN  uint64      `json:"repair_lsn,omitempty"`
+}
+
+// SlaveCheck is the result of comparing one table with one slave.
+type SlaveCheck struct {
+	Address string      `json:"address"`
+	Chunks  []ChunkDiff `json:"differing_chunks"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// TableCheck is the result of checking one table.
+type TableCheck struct {
+	DBName string       `json:"dbname"`
+	Table  string       `json:"table"`
+	Key    string       `json:"key,omitempty"`
+	Chunks int          `json:"chunks"`
+	Slaves []SlaveCheck `json:"slaves"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// ConsistencyReport is the result of a consistency check.
+type ConsistencyReport struct {
+	LSN         uint64       `json:"lsn"`
+	Tables      []TableCheck `json:"tables"`
+	Differences int          `json:"differences"`
+	Repaired    int          `json:"repaired"`
+}
+
+// rangePredicates returns the WHERE clause of a chunk.
+func rangePredicates(key string, r ChunkRange) []Predicate {
+	if key == "" {
+		return nil
+	}
+	var preds []Predicate
+	if r.Lower != nil {
+		preds = append(preds, Predicate{Column: key, Op: ">", Value: r.Lower})
+	}
+	if r.Upper != nil {
+		preds = append(preds, Predicate{Column: key, Op: "<=", Value: r.Upper})
+	}
+	return preds
+}
+
+// chunkQuery builds the statement that reads every row of a chunk.
+func chunkQuery(req ChecksumRequest, r ChunkRange) (string, []interface{}, error) {
+	if err := checkIdent("database", req.DBName); err != nil {
+		return "", nil, err
+	}
+	if err := checkIdent("table", req.Table); err != nil {
+		return "", nil, err
+	}
+	query := "SELECT * FROM " + quoteIdent(req.DBName) + "." + quoteIdent(req.Table)
+	preds := rangePredicates(req.Key, r)
+	if len(preds) == 0 {
+		return query, nil, nil
+	}
+	where, args, err := buildWhere(preds)
+	if err != nil {
+		return "", nil, err
+	}
+	return query + " WHERE " + where, args, nil
+}
+
+// checksumChunks checksums the rows of every requested range on this node.
+// A chunk's checksum is the sum of the hashes of its rows, so it does not
+// depend on the order the rows are read in.
+func checksumChunks(ctx context.Context, q querier, req ChecksumRequest) ([]ChunkChecksum, error) {
+	sums := make([]ChunkChecksum, 0, len(req.Ranges))
+	for _, r := range req.Ranges {
+		query, args, err := chunkQuery(req, r)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			countMySQLError("checksum")
+			return nil, err
+		}
+		_, results, err := scanRowValues(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var sum uint64
+		for _, row := range results {
+			h := sha256.New()
+			for _, v := range row {
+				if v == nil {
+					h.Write([]byte{0})
+					continue
+				}
+				h.Write([]byte{1})
+				fmt.Fprint(h, v)
+				h.Write([]byte{0})
+			}
+			sum += binary.BigEndian.Uint64(h.Sum(nil))
+		}
+		sums = append(sums, ChunkChecksum{Rows: len(results), Checksum: strconv.FormatUint(sum, 16)})
+	}
+	return sums, nil
+}
+
+// scanRowValues reads a result set as rows of values in column order.
+func scanRowValues(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	binary, err := binaryColumns(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	var results [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		pointers := make([]interface{}, len(cols))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		for i := range values {
+			values[i] = rowValue(values[i], binary[i])
+		}
+		results = append(results, values)
+	}
+	return cols, results, rows.Err()
+}
+
+// errTooLargeUnkeyed is returned for a table without a single-column primary
+// key that is too large to check as one chunk.
+var errTooLargeUnkeyed = fmt.Errorf("the table has no single-column primary key and more than %d rows, it is not checked", maxUnkeyedRows)
+
+// chunkRanges splits a table into ranges of size rows by its primary key.
+func chunkRanges(ctx context.Context, dbname, table, key string, size int) ([]ChunkRange, error) {
+	if key == "" {
+		var count int
+		err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", quoteIdent(dbname), quoteIdent(table))).Scan(&count)
+		if err != nil {
+			countMySQLError("checksum")
+			return nil, err
+		}
+		if count > maxUnkeyedRows {
+			return nil, errTooLargeUnkeyed
+		}
+		return []ChunkRange{{}}, nil
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s.%s ORDER BY %s",
+		quoteIdent(key), quoteIdent(dbname), quoteIdent(table), quoteIdent(key)))
+	if err != nil {
+		countMySQLError("checksum")
+		return nil, err
+	}
+	defer rows.Close()
+	binary, err := binaryColumns(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ranges []ChunkRange
+		lower  interface{}
+		count  int
+	)
+	for rows.Next() {
+		var v interface{}
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		if count++; count == size {
+			upper := rowValue(v, binary[0])
+			ranges = append(ranges, ChunkRange{Lower: lower, Upper: upper})
+			lower, count = upper, 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The last range is open so that it also covers rows a slave has beyond
+	// the master's largest key.
+	return append(ranges, ChunkRange{Lower: lower}), nil
+}
+
+// primaryKey returns the table's primary key column, or "" if the key does
+// not consist of exactly one column.
+func primaryKey(schema []ColumnDef) string {
+	key := ""
+	for _, col := range schema {
+		if col.PrimaryKey {
+			if key != "" {
+				return ""
+			}
+			key = col.Name
+		}
+	}
+	return key
+}
+
+// fetchChecksums asks a slave for the checksums of req's ranges. It also
+// returns the LSN the slave's checksums were taken at.
+func fetchChecksums(ctx context.Context, addr string, req ChecksumRequest) ([]ChunkChecksum, uint64, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, checksumRPCTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+"/replication/checksum", bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(termHeader, strconv.FormatUint(currentTerm(), 10))
+	if id := requestID(ctx); id != "" {
+		httpReq.Header.Set(requestIDHeader, id)
+	}
+	resp, err := nodeClient(0).Do(httpReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, responseError(resp)
+	}
+	var sums []ChunkChecksum
+	if err := decodeJSON(resp.Body, &sums); err != nil {
+		return nil, 0, err
+	}
+	if len(sums) != len(req.Ranges) {
+		return nil, 0, fmt.Errorf("slave returned %d checksums for %d chunks", len(sums), len(req.Ranges))
+	}
+	lsn, err := strconv.ParseUint(resp.Header.Get(appliedLSNHeader), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("slave did not report the LSN of its checksums")
+	}
+	return sums, lsn, nil
+}
+
+// checkConsistency compares the selected tables between the master and its
+// slaves and, if asked to, repairs the chunks that differ.
+func checkConsistency(ctx context.Context, opts CheckOptions) (ConsistencyReport, error) {
+	report := ConsistencyReport{LSN: replicationLog.LastLSN(), Tables: []TableCheck{}}
+	if !isCurrentMaster() {
+		return report, errNotMaster
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = defaultChunkSize
+	}
+	if opts.Table != "" && opts.DBName == "" {
+		return report, invalidf("dbname is required with table")
+	}
+
+	var slaves []*slaveState
+	if len(opts.Slaves) == 0 {
+		rangeSlaves(func(s *slaveState) {
+			if s.isOnline() {
+				slaves = append(slaves, s)
+			}
+		})
+	}
+	for _, addr := range opts.Slaves {
+		v, ok := slaveConnections.Load(strings.TrimSuffix(addr, "/"))
+		if !ok {
+			return report, invalidf("%s is not a registered slave", addr)
+		}
+		slaves = append(slaves, v.(*slaveState))
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return report, err
+	}
+	schema, err := snapshotSchema(ctx, conn)
+	conn.Close()
+	if err != nil {
+		return report, err
+	}
+
+	for _, record := range schema {
+		if record.Type != "table" || (opts.DBName != "" && record.DBName != opts.DBName) ||
+			(opts.Table != "" && record.Table != opts.Table) {
+			continue
+		}
+		check, err := checkTable(ctx, record.DBName, record.Table, primaryKey(record.Schema), slaves, opts)
+		if err == errTooLargeUnkeyed {
+			check.Error = err.Error()
+			report.Tables = append(report.Tables, check)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("checking %s.%s: %w", record.DBName, record.Table, err)
+		}
+		for _, s := range check.Slaves {
+			report.Differences += len(s.Chunks)
+			for _, c := range s.Chunks {
+				if c.RepairLSN > 0 {
+					report.Repaired++
+				}
+			}
+		}
+		report.Tables = append(report.Tables, check)
+	}
+	if opts.DBName != "" && len(report.Tables) == 0 {
+		return report, invalidf("no table matches %s.%s", opts.DBName, opts.Table)
+	}
+	return report, nil
+}
+
+func checkTable(ctx context.Context, dbname, table, key string, slaves []*slaveState, opts CheckOptions) (TableCheck, error) {
+	check := TableCheck{DBName: dbname, Table: table, Key: key, Slaves: []SlaveCheck{}}
+	ranges, err := chunkRanges(ctx, dbname, table, key, opts.ChunkSize)
+	if err != nil {
+		return check, err
+	}
+	check.Chunks = len(ranges)
+	req := ChecksumRequest{DBName: dbname, Table: table, Key: key, Ranges: ranges}
+	master, err := checksumChunks(ctx, db, req)
+	if err != nil {
+		return check, err
+	}
+
+	for _, s := range slaves {
+		if !s.filter().allowsTable(dbname, table) {
+			// The slave does not replicate this table.
+			continue
+		}
+		result := SlaveCheck{Address: s.addr, Chunks: []ChunkDiff{}}
+		sums, _, err := fetchChecksums(ctx, s.addr, req)
+		if err != nil {
+			result.Error = err.Error()
+			check.Slaves = append(check.Slaves, result)
+			continue
+		}
+		for i := range ranges {
+			if sums[i] == master[i] {
+				continue
+			}
+			diff, differs, err := confirmChunk(ctx, s, req, i, opts.Repair)
+			if err != nil {
+				result.Error = err.Error()
+				break
+			}
+			if differs {
+				result.Chunks = append(result.Chunks, diff)
+			}
+		}
+		check.Slaves = append(check.Slaves, result)
+	}
+	return check, nil
+}
+
+// consistentView starts a transaction with a consistent snapshot on a
+// connection of its own while mu pauses writes, and returns it with the LSN it
+// sees. The caller ends it with closeView.
+func consistentView(ctx context.Context, mu *sync.Mutex) (*sql.Conn, uint64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	lockCtx, cancel := context.WithTimeout(ctx, checksumLockTimeout)
+	defer cancel()
+	mu.Lock()
+	_, err = conn.ExecContext(lockCtx, "START TRANSACTION WITH CONSISTENT SNAPSHOT")
+	lsn := replicationLog.LastLSN()
+	mu.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	return conn, lsn, nil
+}
+
+func closeView(conn *sql.Conn) {
+	conn.ExecContext(context.Background(), "ROLLBACK")
+	conn.Close()
+}
+
+// touchesTable reports whether a log entry after from, up to and including
+// to, writes to dbname.table.
+func touchesTable(from, to uint64, dbname, table string) (bool, error) {
+	for from < to {
+		limit := 1000
+		if to-from < uint64(limit) {
+			limit = int(to - from)
+		}
+		entries, err := replicationLog.Entries(from, limit, entryBatchBytes)
+		if err != nil {
+			return true, err
+		}
+		if len(entries) == 0 {
+			return false, nil
+		}
+		for _, entry := range entries {
+			if taskTouches(entry.Task, dbname, table) {
+				return true, nil
+			}
+			from = entry.LSN
+		}
+	}
+	return false, nil
+}
+
+func taskTouches(task ReplicationTask, dbname, table string) bool {
+	if task.Operation == "transaction" {
+		for _, op := range task.Operations {
+			if taskTouches(op, dbname, table) {
+				return true
+			}
+		}
+		return false
+	}
+	return task.DBName == dbname && (task.Table == "" || task.Table == table)
+}
+
+// confirmChunk compares chunk i again at a fence LSN, so both sides hold the
+// same writes. With repair set it replicates the master's rows of a chunk
+// that still differs. Writes are paused only to open the master's view and
+// to log a repair.
+func confirmChunk(ctx context.Context, s *slaveState, req ChecksumRequest, i int, repair bool) (ChunkDiff, bool, error) {
+	for attempt := 0; attempt < checksumAttempts; attempt++ {
+		diff, differs, settled, err := compareAtFence(ctx, s, req, i, repair)
+		if err != nil || settled {
+			return diff, differs, err
+		}
+	}
+	return ChunkDiff{}, false, fmt.Errorf("chunk %d was written to during every comparison, run the check again", i)
+}
+
+// compareAtFence makes one attempt for confirmChunk. It reports settled as
+// false if writes to the table got in the way.
+func compareAtFence(ctx context.Context, s *slaveState, req ChecksumRequest, i int, repair bool) (diff ChunkDiff, differs, settled bool, err error) {
+	r := req.Ranges[i]
+	diff = ChunkDiff{Index: i, Lower: r.Lower, Upper: r.Upper}
+	one := req
+	one.Ranges = []ChunkRange{r}
+
+	if _, err := leadership(); err != nil {
+		return diff, false, true, err
+	}
+	view, fence, err := consistentView(ctx, &writeMu)
+	if err != nil {
+		return diff, false, true, err
+	}
+	defer closeView(view)
+
+	if err := waitForAck(s, fence, checksumAckWait); err != nil {
+		return diff, false, true, err
+	}
+	master, err := checksumChunks(ctx, view, one)
+	if err != nil {
+		return diff, false, true, err
+	}
+	slave, slaveLSN, err := fetchChecksums(ctx, s.addr, one)
+	if err != nil {
+		return diff, false, true, err
+	}
+	if slaveLSN != fence {
+		from, to := fence, slaveLSN
+		if to < from {
+			from, to = to, from
+		}
+		moved, err := touchesTable(from, to, req.DBName, req.Table)
+		if err != nil || moved {
+			return diff, false, false, err
+		}
+	}
+	if master[0] == slave[0] {
+		return diff, false, true, nil
+	}
+	diff.MasterRows, diff.SlaveRows = master[0].Rows, slave[0].Rows
+	if !repair {
+		return diff, true, true, nil
+	}
+
+	query, args, err := chunkQuery(one, r)
+	if err != nil {
+		return diff, true, true, err
+	}
+	rows, err := view.QueryContext(ctx, query, args...)
+	if err != nil {
+		countMySQLError("checksum")
+		return diff, true, true, err
+	}
+	cols, values, err := scanRowValues(rows)
+	rows.Close()
+	if err != nil {
+		return diff, true, true, err
+	}
+	task := ReplicationTask{
+		Operation: "repair",
+		DBName:    req.DBName,
+		Table:     req.Table,
+		Where:     rangePredicates(req.Key, r),
+		RequestID: requestID(ctx),
+	}
+	for _, row := range values {
+		m := make(map[string]interface{}, len(cols))
+		for j, col := range cols {
+			m[col] = row[j]
+		}
+		task.Rows = append(task.Rows, m)
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	term, err := leadership()
+	if err != nil {
+		return diff, true, true, err
+	}
+	// The rows are the master's at the fence; writes to the table since
+	// then would be undone by the repair.
+	if moved, err := touchesTable(fence, replicationLog.LastLSN(), req.DBName, req.Table); err != nil || moved {
+		return diff, true, false, err
+	}
+	// The master already holds these rows, so the repair only goes into
+	// the log for the slaves.
+	entry, err := replicationLog.Append(term, task)
+	if err != nil {
+		return diff, true, true, err
+	}
+	if err := recordApplied(db, entry.LSN, entry.Term); err != nil {
+		// Replaying the repair on the master rewrites the same rows.
+		markReplayNeeded()
+	}
+	diff.RepairLSN = entry.LSN
+	slog.Warn("Repairing chunk that differs on a slave", append(taskAttrs(entry.LSN, task),
+		"slave", s.addr, "chunk", i, "master_rows", diff.MasterRows, "slave_rows", diff.SlaveRows)...)
+	return diff, true, true, nil
+}
+
+// serveChecksums answers a master's checksum request with this node's
+// checksums.
+func serveChecksums(w http.ResponseWriter, r *http.Request) {
+	var req ChecksumRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if req.Key != "" {
+		if err := checkIdent("column", req.Key); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid key", err)
+			return
+		}
+	}
+	// The view is taken between two applied entries, so the checksums
+	// match the LSN reported with them.
+	view, lsn, err := consistentView(r.Context(), &applyMu)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to checksum", err)
+		return
+	}
+	defer closeView(view)
+	sums, err := checksumChunks(r.Context(), view, req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to checksum", err)
+		return
+	}
+	w.Header().Set(appliedLSNHeader, strconv.FormatUint(lsn, 10))
+	writeJSON(w, http.StatusOK, sums)
+}
+
+func checksumHandler(w http.ResponseWriter, r *http.Request) {
+	var opts CheckOptions
+	if err := decodeJSON(r.Body, &opts); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	report, err := checkConsistency(r.Context(), opts)
+	switch {
+	case err == errNotMaster || err == errNoQuorum:
+		writeNotMaster(w, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "Consistency check failed", err)
+	default:
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+// printConsistencyReport shows a consistency check on the dashboard.
+func printConsistencyReport(report ConsistencyReport) {
+	for _, t := range report.Tables {
+		if t.Error != "" {
+			fmt.Printf("%s.%s: %s\n", t.DBName, t.Table, t.Error)
+		}
+		for _, s := range t.Slaves {
+			switch {
+			case s.Error != "":
+				fmt.Printf("%s.%s on %s: error: %s\n", t.DBName, t.Table, s.Address, s.Error)
+			case len(s.Chunks) == 0:
+				fmt.Printf("%s.%s on %s: consistent (%d chunks)\n", t.DBName, t.Table, s.Address, t.Chunks)
+			default:
+				fmt.Printf("%s.%s on %s: %d of %d chunks differ\n", t.DBName, t.Table, s.Address, len(s.Chunks), t.Chunks)
+				for _, c := range s.Chunks {
+					fmt.Printf("  chunk %d (%v, %v]: %d rows on the master, %d on the slave", c.Index, c.Lower, c.Upper, c.MasterRows, c.SlaveRows)
+					if c.RepairLSN > 0 {
+						fmt.Printf(", repaired at LSN %d", c.RepairLSN)
+					}
+					fmt.Println()
+				}
+			}
+		}
+	}
+	fmt.Printf("%d differing chunks, %d repaired\n", report.Differences, report.Repaired)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChecksumChunks(t *testing.T) {
+	// Each table holds the rows of one variant of the same chunk.
+	tables := map[string][][]driver.Value{
+		"base":      {{int64(1), "pen"}, {int64(2), "ink"}},
+		"reordered": {{int64(2), "ink"}, {int64(1), "pen"}},
+		"changed":   {{int64(1), "pen"}, {int64(2), "inks"}},
+		"missing":   {{int64(1), "pen"}},
+		"null":      {{int64(1), "pen"}, {int64(2), nil}},
+		"empty":     {{int64(1), "pen"}, {int64(2), ""}},
+		"shifted":   {{int64(1), "pe"}, {int64(2), "nink"}},
+		"duplicate": {{int64(1), "pen"}, {int64(2), "ink"}, {int64(2), "ink"}},
+	}
+	withFakeDB(t, func(query string, args []interface{}) (driver.Result, *fakeRows, error) {
+		for name, rows := range tables {
+			if strings.HasPrefix(query, "SELECT * FROM `shop`.`"+name+"`") {
+				return nil, &fakeRows{columns: []string{"id", "item"}, values: rows}, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("unexpected query %s", query)
+	})
+
+	checksum := func(table string) ChunkChecksum {
+		t.Helper()
+		sums, err := checksumChunks(context.Background(), db, ChecksumRequest{DBName: "shop", Table: table, Key: "id", Ranges: []ChunkRange{{}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sums[0]
+	}
+	base := checksum("base")
+	if base.Rows != 2 {
+		t.Errorf("base chunk has %d rows, want 2", base.Rows)
+	}
+
+	tests := []struct {
+		table string
+		same  bool
+	}{
+		{"reordered", true},
+		{"changed", false},
+		{"missing", false},
+		{"null", false},
+		{"empty", false},
+		{"shifted", false},
+		{"duplicate", false},
+	}
+	for _, tt := range tests {
+		if got := checksum(tt.table); (got == base) != tt.same {
+			t.Errorf("%s: checksum %+v, base %+v, want equal %v", tt.table, got, base, tt.same)
+		}
+	}
+	if checksum("null") == checksum("empty") {
+		t.Error("NULL and the empty string have the same checksum")
+	}
+}
+
+func TestChunkQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		r     ChunkRange
+		query string
+		args  []interface{}
+	}{
+		{"whole table", "", ChunkRange{}, "SELECT * FROM `shop`.`orders`", nil},
+		{"no key ignores bounds", "", ChunkRange{Lower: 1, Upper: 5}, "SELECT * FROM `shop`.`orders`", nil},
+		{"open range", "id", ChunkRange{}, "SELECT * FROM `shop`.`orders`", nil},
+		{"first chunk", "id", ChunkRange{Upper: 1000}, "SELECT * FROM `shop`.`orders` WHERE `id` <= ?", []interface{}{1000}},
+		{"middle chunk", "id", ChunkRange{Lower: 1000, Upper: 2000}, "SELECT * FROM `shop`.`orders` WHERE (`id` > ? AND `id` <= ?)", []interface{}{1000, 2000}},
+		{"last chunk", "id", ChunkRange{Lower: 2000}, "SELECT * FROM `shop`.`orders` WHERE `id` > ?", []interface{}{2000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := chunkQuery(ChecksumRequest{DBName: "shop", Table: "orders", Key: tt.key}, tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("chunkQuery = %q %v, want %q %v", query, args, tt.query, tt.args)
+			}
+		})
+	}
+
+	if _, _, err := chunkQuery(ChecksumRequest{DBName: "shop", Table: "a`b"}, ChunkRange{}); err == nil {
+		t.Error("chunkQuery accepted an invalid table name")
+	}
+}
+
+func TestPrimaryKey(t *testing.T) {
+	tests := []struct {
+		schema []ColumnDef
+		want   string
+	}{
+		{[]ColumnDef{{Name: "id", PrimaryKey: true}, {Name: "item"}}, "id"},
+		{[]ColumnDef{{Name: "item"}, {Name: "code", PrimaryKey: true}}, "code"},
+		{[]ColumnDef{{Name: "a", PrimaryKey: true}, {Name: "b", PrimaryKey: true}}, ""},
+		{[]ColumnDef{{Name: "item", Unique: true}}, ""},
+	}
+	for _, tt := range tests {
+		if got := primaryKey(tt.schema); got != tt.want {
+			t.Errorf("primaryKey(%+v) = %q, want %q", tt.schema, got, tt.want)
+		}
+	}
+}
+
+func TestTaskTouches(t *testing.T) {
+	tests := []struct {
+		name string
+		task ReplicationTask
+		want bool
+	}{
+		{"same table", ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders"}, true},
+		{"other table", ReplicationTask{Operation: "insert", DBName: "shop", Table: "users"}, false},
+		{"other database", ReplicationTask{Operation: "insert", DBName: "crm", Table: "orders"}, false},
+		{"database operation", ReplicationTask{Operation: "dropdb", DBName: "shop"}, true},
+		{"transaction", ReplicationTask{Operation: "transaction", Operations: []ReplicationTask{
+			{Operation: "insert", DBName: "shop", Table: "users"}, {Operation: "delete", DBName: "shop", Table: "orders"},
+		}}, true},
+		{"transaction elsewhere", ReplicationTask{Operation: "transaction", Operations: []ReplicationTask{
+			{Operation: "insert", DBName: "shop", Table: "users"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		if got := taskTouches(tt.task, "shop", "orders"); got != tt.want {
+			t.Errorf("%s: taskTouches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+func clearScreen() {
+	if runtime.GOOS == "windows" {
+		cmd := exec.Command("cmd", "/c", "cls")
+		cmd.Stdout = os.Stdout
+		cmd.Run()
+	} else {
+		fmt.Print("\033[H\033[2J")
+	}
+}
+
+// quoteIdent quotes a MySQL identifier such as a database, table or column
+// name.
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// columnValue converts a scanned column into something that prints and
+// encodes nicely. The MySQL driver returns most types as []byte.
+func columnValue(val interface{}) interface{} {
+	if b, ok := val.([]byte); ok {
+		return string(b)
+	}
+	return val
+}
+
+// binaryKey is the key of the JSON object that carries the bytes of a binary
+// value, base64 encoded: {"$binary": "AAEC"}. A JSON string cannot hold
+// arbitrary bytes.
+const binaryKey = "$binary"
+
+// binaryValue is the value of a binary column as it is sent between nodes.
+type binaryValue struct {
+	Bytes []byte `json:"$binary"`
+}
+
+// binaryTypes are the column types, as the MySQL driver names them, whose
+// values are bytes rather than text.
+var binaryTypes = map[string]bool{
+	"BINARY": true, "VARBINARY": true, "BIT": true, "GEOMETRY": true,
+	"TINYBLOB": true, "BLOB": true, "MEDIUMBLOB": true, "LONGBLOB": true,
+}
+
+// binaryColumns reports for each column of rows whether it holds bytes.
+func binaryColumns(rows *sql.Rows) ([]bool, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	binary := make([]bool, len(types))
+	for i, t := range types {
+		binary[i] = binaryTypes[t.DatabaseTypeName()]
+	}
+	return binary, nil
+}
+
+// rowValue is columnValue for values that are sent to other nodes: the
+// bytes of a binary column are kept in a binaryValue.
+func rowValue(val interface{}, binary bool) interface{} {
+	if b, ok := val.([]byte); ok && binary {
+		return binaryValue{Bytes: append([]byte(nil), b...)}
+	}
+	return columnValue(val)
+}
+
+// scanRows reads every row of a result set into a map keyed by column name.
+func scanRows(rows *sql.Rows) ([]string, []map[string]interface{}, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	var results []map[string]interface{}
+	for rows.Next() {
+		columns := make([]interface{}, len(cols))
+		columnPointers := make([]interface{}, len(cols))
+		for i := range columns {
+			columnPointers[i] = &columns[i]
+		}
+
+		if err := rows.Scan(columnPointers...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		row := make(map[string]interface{})
+		for i, col := range cols {
+			row[col] = columnValue(columns[i])
+		}
+		results = append(results, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error during rows iteration: %w", err)
+	}
+	return cols, results, nil
+}
+
+// printTable renders rows as fixed width columns on the dashboard. When cols
+// is empty the column order is taken from the first row.
+func printTable(cols []string, results []map[string]interface{}) {
+	if len(results) == 0 {
+		fmt.Println("No records found")
+		return
+	}
+	if len(cols) == 0 {
+		for k := range results[0] {
+			cols = append(cols, k)
+		}
+		sort.Strings(cols)
+	}
+
+	// Print column headers
+	for _, col := range cols {
+		fmt.Printf("%-15s", col)
+	}
+	fmt.Println("\n" + strings.Repeat("-", len(cols)*15))
+
+	// Print rows
+	for _, row := range results {
+		for _, col := range cols {
+			val := row[col]
+			if val == nil {
+				fmt.Printf("%-15s", "NULL")
+				continue
+			}
+			fmt.Printf("%-15v", val)
+		}
+		fmt.Println()
+	}
+}
+
+// printQuery runs a query against the local database and prints the result.
+func printQuery(query string, args ...interface{}) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		fmt.Println("Error selecting records:", err)
+		return
+	}
+	defer rows.Close()
+
+	cols, results, err := scanRows(rows)
+	if err != nil {
+		fmt.Println("Error reading records:", err)
+		return
+	}
+	printTable(cols, results)
+}
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends a structured error response. Validation errors become
+// 400 Bad Request, everything else uses the given status.
+func writeError(w http.ResponseWriter, status int, message string, err error) {
+	code := "internal_error"
+	var verr *validationError
+	switch {
+	case errors.As(err, &verr):
+		status = http.StatusBadRequest
+		code = "invalid_request"
+	case status == http.StatusBadRequest:
+		code = "invalid_request"
+	case status == http.StatusNotFound:
+		code = "not_found"
+	case status == http.StatusConflict:
+		code = "conflict"
+	case status == http.StatusServiceUnavailable:
+		code = "unavailable"
+	case err != nil:
+		code = "database_error"
+	}
+
+	if err != nil {
+		message += ": " + err.Error()
+	}
+	writeJSON(w, status, map[string]string{"error": message, "code": code})
+}
+
+// decodeJSON decodes a request or log body. Numbers are kept as json.Number
+// so that large integers and decimals reach MySQL unchanged.
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompt prints label and reads one line from the terminal.
+func prompt(label string) string {
+	fmt.Print(label)
+	line, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside parentheses or
+// single quotes.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(parts) > 0 {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+// parseLiteral turns dashboard input into a value: NULL becomes nil and
+// surrounding single quotes are removed.
+func parseLiteral(s string) interface{} {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "null") {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// parseColumnDefs reads a dashboard column list such as
+// "id INT PRIMARY KEY AUTO_INCREMENT, name VARCHAR(255) NOT NULL".
+func parseColumnDefs(s string) ([]ColumnDef, error) {
+	var columns []ColumnDef
+	for _, part := range splitTopLevel(s, ',') {
+		fields := strings.Fields(part)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("column %q needs a name and a type", part)
+		}
+
+		col := ColumnDef{Name: fields[0]}
+		rest := strings.Join(fields[1:], " ")
+		for _, flag := range []string{"PRIMARY KEY", "NOT NULL", "AUTO_INCREMENT", "UNIQUE"} {
+			if i := strings.Index(strings.ToUpper(rest), flag); i >= 0 {
+				rest = rest[:i] + rest[i+len(flag):]
+				switch flag {
+				case "PRIMARY KEY":
+					col.PrimaryKey = true
+				case "NOT NULL":
+					col.NotNull = true
+				case "AUTO_INCREMENT":
+					col.AutoIncrement = true
+				case "UNIQUE":
+					col.Unique = true
+				}
+			}
+		}
+		col.Type = strings.Join(strings.Fields(rest), " ")
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// parseAssignments reads dashboard input such as "id=1, name='John'".
+func parseAssignments(s string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, part := range splitTopLevel(s, ',') {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected column=value, got %q", part)
+		}
+		values[strings.TrimSpace(name)] = parseLiteral(value)
+	}
+	return values, nil
+}
+
+// parseConditions reads dashboard input such as "id=1, age>=18"; the
+// conditions are combined with AND.
+func parseConditions(s string) ([]Predicate, error) {
+	var preds []Predicate
+	for _, part := range splitTopLevel(s, ',') {
+		name, op, value, ok := cutComparison(part)
+		if !ok {
+			return nil, fmt.Errorf("expected a comparison such as id=1, got %q", part)
+		}
+		p := Predicate{Column: strings.TrimSpace(name), Op: op, Value: parseLiteral(value)}
+		if p.Value == nil && (op == "=" || op == "!=" || op == "<>") {
+			p.Op, p.Value = "is null", nil
+			if op != "=" {
+				p.Op = "is not null"
+			}
+		}
+		preds = append(preds, p)
+	}
+	return preds, nil
+}
+
+// cutComparison splits a condition such as "name='x>=y'" around its first
+// comparison operator outside single quotes.
+func cutComparison(s string) (name, op, value string, ok bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\'' {
+			quoted = !quoted
+			continue
+		}
+		if quoted {
+			continue
+		}
+		for _, op := range []string{"<=", ">=", "!=", "<>", "=", "<", ">"} {
+			if strings.HasPrefix(s[i:], op) {
+				return s[:i], op, s[i+len(op):], true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// postJSON sends v to url as a JSON request body, tagged with a request ID.
+func postJSON(url, id string, v interface{}) (*http.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, id)
+	return nodeClient(0).Do(req)
+}
+
+// responseError turns a non-OK response into an error, using the message of
+// a structured error body when there is one.
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return errors.New(body.Error)
+	}
+	if msg := strings.TrimSpace(string(data)); msg != "" {
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return errors.New(resp.Status)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConditions(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Predicate
+		err  bool
+	}{
+		{"id=1", []Predicate{{Column: "id", Op: "=", Value: "1"}}, false},
+		{"id = 1, age>=18", []Predicate{{Column: "id", Op: "=", Value: "1"}, {Column: "age", Op: ">=", Value: "18"}}, false},
+		{"a<=1,b<>2,c!=3,d<4,e>5", []Predicate{
+			{Column: "a", Op: "<=", Value: "1"},
+			{Column: "b", Op: "<>", Value: "2"},
+			{Column: "c", Op: "!=", Value: "3"},
+			{Column: "d", Op: "<", Value: "4"},
+			{Column: "e", Op: ">", Value: "5"},
+		}, false},
+		{"name='x>=y'", []Predicate{{Column: "name", Op: "=", Value: "x>=y"}}, false},
+		{"name>='a=b'", []Predicate{{Column: "name", Op: ">=", Value: "a=b"}}, false},
+		{"name='a, b', id=2", []Predicate{{Column: "name", Op: "=", Value: "a, b"}, {Column: "id", Op: "=", Value: "2"}}, false},
+		{"name='it''s=1'", []Predicate{{Column: "name", Op: "=", Value: "it's=1"}}, false},
+		{"deleted=NULL", []Predicate{{Column: "deleted", Op: "is null"}}, false},
+		{"deleted != null", []Predicate{{Column: "deleted", Op: "is not null"}}, false},
+		{"name='NULL'", []Predicate{{Column: "name", Op: "=", Value: "NULL"}}, false},
+		{"id", nil, true},
+		{"'a=b'", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseConditions(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseConditions(%q) err = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConditions(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTopLevel(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a, b ,c", []string{"a", "b", "c"}},
+		{"id INT, price DECIMAL(10,2)", []string{"id INT", "price DECIMAL(10,2)"}},
+		{"s ENUM('a,b','c'), t INT", []string{"s ENUM('a,b','c')", "t INT"}},
+		{"a,", []string{"a", ""}},
+	}
+	for _, tt := range tests {
+		if got := splitTopLevel(tt.in, ','); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitTopLevel(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
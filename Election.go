@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const defaultElectionTimeout = 10 * time.Second
+
+// maxTermJump is how far ahead of this node's term a candidate's term may be
+// when the cluster does not authenticate its nodes. Pre-votes keep terms from
+// racing ahead, so a larger jump is a forged or corrupted vote request and is
+// refused rather than persisted. Terms learned from the master, and vote
+// requests from authenticated nodes, are never bounded: a node that was down for long
+// may be any number of terms behind.
+const maxTermJump = 1000
+
+// electionState is what a node has to remember across restarts: the highest
+// term it has seen, whom it voted for in that term and, once known, the
+// master of that term. Persisting it guarantees a node never votes twice in
+// the same term.
+type electionState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+	Master   string `json:"master,omitempty"`
+}
+
+var (
+	election          electionState
+	lastMasterContact time.Time
+	electionDeadline  time.Time // when this slave starts an election unless it hears from a master
+	lastQuorum        time.Time // when this master last confirmed that a majority follows it
+)
+
+// termHeader carries the sender's term on every replication request between
+// nodes, masterHeader the address of the master sending it. A node rejects
+// messages from an older term.
+// prevTermHeader carries the term of the entry just before the ones sent, so
+// the receiver can check that its log matches the master's up to there.
+const (
+	termHeader     = "X-Term"
+	masterHeader   = "X-Master"
+	prevTermHeader = "X-Prev-Term"
+)
+
+var (
+	errNotMaster = errors.New("this node is not the master")
+	errNoQuorum  = errors.New("this node cannot reach a majority of the cluster and does not accept writes")
+)
+
+// voteRequest asks a peer to vote for candidate as master of term. A pre-vote
+// only asks whether the peer would grant the vote and changes nothing on
+// either side, so a node that was merely cut off cannot force everybody into
+// a new term.
+type voteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastLSN   uint64 `json:"last_lsn"`
+	LastTerm  uint64 `json:"last_term"`
+	PreVote   bool   `json:"pre_vote,omitempty"`
+	Transfer  bool   `json:"transfer,omitempty"`
+}
+
+type voteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+	Master  string `json:"master,omitempty"` // the master the voter follows, if it has one
+	Reason  string `json:"reason,omitempty"`
+}
+
+// leaderAnnouncement tells the other nodes who won the election for a term.
+// The master sends it as its heartbeat, together with the end of its log.
+type leaderAnnouncement struct {
+	Term     uint64    `json:"term"`
+	Master   string    `json:"master"`
+	LSN      uint64    `json:"lsn"`
+	Topology *Topology `json:"topology,omitempty"`
+}
+
+func electionPath() string {
+	return filepath.Join(cfg.DataDir, "election.json")
+}
+
+// loadElectionState restores the persisted term and vote. Once an election
+// has taken place its result wins over the configured role and master.
+func loadElectionState() error {
+	data, err := os.ReadFile(electionPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &election); err != nil {
+		return fmt.Errorf("parsing %s: %w", electionPath(), err)
+	}
+	if election.Master != "" {
+		isMaster = election.Master == cfg.Advertise
+		masterAddress = election.Master
+	}
+	return nil
+}
+
+func saveElectionLocked() error {
+	data, err := json.Marshal(election)
+	if err != nil {
+		return err
+	}
+	tmp := electionPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, electionPath())
+}
+
+func isCurrentMaster() bool {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	return isMaster
+}
+
+func currentMaster() string {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	return masterAddress
+}
+
+func currentTerm() uint64 {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	return election.Term
+}
+
+// leadership returns the term in which this node may commit writes. A master
+// with peers only accepts writes while a majority of the cluster has
+// confirmed it within the last election timeout; by then the slaves may
+// already be electing a new master, so a cut off master stops writing before
+// the new one can start.
+func leadership() (uint64, error) {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	if !isMaster {
+		return 0, errNotMaster
+	}
+	if len(electionPeers()) > 0 && time.Since(lastQuorum) >= electionTimeout() {
+		return 0, errNoQuorum
+	}
+	return election.Term, nil
+}
+
+// quorum is the number of nodes, including this one, that form a majority.
+func quorum(peers int) int {
+	return (peers+1)/2 + 1
+}
+
+func electionTimeout() time.Duration {
+	if cfg.ElectionTimeoutMS > 0 {
+		return time.Duration(cfg.ElectionTimeoutMS) * time.Millisecond
+	}
+	return defaultElectionTimeout
+}
+
+// resetElectionTimerLocked picks a new random deadline between one and two
+// election timeouts, so that slaves rarely start competing elections at once.
+func resetElectionTimerLocked() {
+	timeout := electionTimeout()
+	electionDeadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+// noteMasterContact records that the current master has been heard from.
+func noteMasterContact() {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	lastMasterContact = time.Now()
+	resetElectionTimerLocked()
+}
+
+// followLeader makes master the master of this node for term, unless the node
+// already knows a newer term. It reports whether the leader was accepted.
+func followLeader(term uint64, master string) bool {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+
+	if term < election.Term || master == "" {
+		return false
+	}
+	if isMaster && master != cfg.Advertise {
+		if term == election.Term {
+			slog.Warn("Ignoring master: this node is the master", "master", master, "term", term)
+			return false
+		}
+		demoteLocked(term)
+	}
+	changed := term > election.Term || election.Master != master
+	if term > election.Term {
+		election.VotedFor = ""
+	}
+	election.Term = term
+	election.Master = master
+	if masterAddress != master {
+		slog.Info("Following master", "master", master, "term", term)
+	}
+	masterAddress = master
+	lastMasterContact = time.Now()
+	resetElectionTimerLocked()
+	if changed {
+		if err := saveElectionLocked(); err != nil {
+			slog.Error("Failed to persist election state", "error", err)
+		}
+	}
+	return true
+}
+
+// electionPeers returns the other voting members of the cluster topology.
+func electionPeers() []string {
+	var peers []string
+	for _, peer := range otherMembers() {
+		if isVoter(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// checkMasterHealth starts an election when this slave has not heard from
+// its master for longer than its randomized election timeout. A node that is
+// alone in the cluster, or not a voting member of it, does not campaign.
+func checkMasterHealth() {
+	if cfg.ReplicationFilter.active() {
+		// A node without every table cannot take over; it still votes.
+		slog.Info("Replication filter set, this node will not stand for election", "filter", cfg.ReplicationFilter.String())
+		return
+	}
+	if len(electionPeers()) == 0 {
+		slog.Warn("No peers in the cluster yet, automatic failover is disabled until nodes are added")
+	}
+
+	nodeMu.Lock()
+	resetElectionTimerLocked()
+	nodeMu.Unlock()
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		if len(electionPeers()) == 0 || !isVoter(cfg.Advertise) {
+			nodeMu.Lock()
+			resetElectionTimerLocked()
+			nodeMu.Unlock()
+			continue
+		}
+		nodeMu.Lock()
+		due := !isMaster && time.Now().After(electionDeadline)
+		silent := time.Since(lastMasterContact)
+		neverSeen := lastMasterContact.IsZero()
+		nodeMu.Unlock()
+		if !due {
+			continue
+		}
+
+		if neverSeen {
+			slog.Warn("Master has not been reachable since startup", "master", currentMaster())
+		} else {
+			slog.Warn("No contact with master", "master", currentMaster(), "silent", silent.Round(time.Second).String())
+		}
+		startElection()
+
+		nodeMu.Lock()
+		resetElectionTimerLocked()
+		nodeMu.Unlock()
+	}
+}
+
+// startElection tries to make this node the master of the next term. It first
+// runs a pre-vote and only increments its term when a majority of the
+// cluster would vote for it.
+func startElection() {
+	runElection(false)
+}
+
+// runElection campaigns for the next term and reports whether this node won.
+// A transfer is an election the current master asked for during a
+// switchover: it skips the pre-vote, and voters grant it even though they
+// have just heard from the master.
+func runElection(transfer bool) bool {
+	nodeMu.Lock()
+	if isMaster || electionInProgress {
+		nodeMu.Unlock()
+		return false
+	}
+	if mysqlAhead() {
+		nodeMu.Unlock()
+		slog.Warn("MySQL holds an entry missing from the log, not standing for election")
+		return false
+	}
+	if !isVoter(cfg.Advertise) {
+		nodeMu.Unlock()
+		slog.Warn("This node is not a voting member, not standing for election")
+		return false
+	}
+	if election.Term == math.MaxUint64 {
+		nodeMu.Unlock()
+		slog.Error("Term is exhausted, this node will not stand for election", "term", election.Term)
+		return false
+	}
+	electionInProgress = true
+	term := election.Term + 1
+	nodeMu.Unlock()
+
+	defer func() {
+		nodeMu.Lock()
+		electionInProgress = false
+		nodeMu.Unlock()
+	}()
+
+	peers := electionPeers()
+	req := voteRequest{
+		Term:      term,
+		Candidate: cfg.Advertise,
+		LastLSN:   replicationLog.LastLSN(),
+		LastTerm:  replicationLog.LastTerm(),
+		PreVote:   !transfer,
+		Transfer:  transfer,
+	}
+	if !transfer && !collectVotes(req, peers) {
+		slog.Info("Pre-vote failed, not starting an election", "term", term)
+		countElection("prevote_failed")
+		return false
+	}
+
+	nodeMu.Lock()
+	if isMaster || election.Term >= term {
+		nodeMu.Unlock()
+		return false
+	}
+	election = electionState{Term: term, VotedFor: cfg.Advertise}
+	if err := saveElectionLocked(); err != nil {
+		nodeMu.Unlock()
+		slog.Error("Failed to persist election state", "error", err)
+		return false
+	}
+	nodeMu.Unlock()
+
+	slog.Info("Starting master election", "term", term, "lsn", req.LastLSN)
+	req.PreVote = false
+	if !collectVotes(req, peers) {
+		slog.Info("Lost the election", "term", term)
+		countElection("lost")
+		return false
+	}
+	countElection("won")
+	promoteToMaster(term)
+	return true
+}
+
+// collectVotes sends req to every peer and reports whether a majority of the
+// cluster, counting this node, granted it. Peers that know the current master
+// or a newer term refuse and share what they know.
+func collectVotes(req voteRequest, peers []string) bool {
+	results := make(chan voteResponse, len(peers))
+	for _, peer := range peers {
+		go func(peer string) {
+			resp, err := askVote(peer, req)
+			if err != nil {
+				resp = voteResponse{Reason: err.Error()}
+			}
+			results <- resp
+		}(peer)
+	}
+
+	votes := 1
+	for range peers {
+		resp := <-results
+		if resp.Granted {
+			votes++
+			continue
+		}
+		if resp.Master != "" {
+			followLeader(resp.Term, resp.Master)
+		} else if resp.Term > req.Term {
+			observeTerm(resp.Term)
+		}
+	}
+	return votes >= quorum(len(peers))
+}
+
+func askVote(peer string, req voteRequest) (voteResponse, error) {
+	client := nodeClient(2 * time.Second)
+	data, err := json.Marshal(req)
+	if err != nil {
+		return voteResponse{}, err
+	}
+	resp, err := client.Post(peer+"/election/vote", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return voteResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return voteResponse{}, responseError(resp)
+	}
+	var vote voteResponse
+	if err := json.NewDecoder(resp.Body).Decode(&vote); err != nil {
+		return voteResponse{}, err
+	}
+	return vote, nil
+}
+
+// observeTerm moves this node to a newer term it learned about, without
+// knowing the master of that term yet.
+func observeTerm(term uint64) {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	if term <= election.Term {
+		return
+	}
+	if isMaster {
+		demoteLocked(term)
+		masterAddress = ""
+	}
+	election = electionState{Term: term}
+	if err := saveElectionLocked(); err != nil {
+		slog.Error("Failed to persist election state", "error", err)
+	}
+}
+
+// plausibleTermLocked reports whether term is within maxTermJump of this
+// node's term, and logs it when it is not.
+func plausibleTermLocked(term uint64) bool {
+	if term <= election.Term || term-election.Term <= maxTermJump {
+		return true
+	}
+	slog.Warn("Ignoring implausible term", "term", election.Term, "received_term", term)
+	return false
+}
+
+// demoteLocked turns this master into a slave because a newer term exists.
+// The senders stop, and the node resynchronizes from the new master like any
+// other slave; writes it committed that the new master never received are
+// discarded by the snapshot it loads.
+func demoteLocked(term uint64) {
+	isMaster = false
+	countStepDown()
+	slog.Warn("Stepping down as master: the cluster is at a newer term", "term", election.Term, "cluster_term", term)
+	rangeSlaves(func(s *slaveState) { s.nudge() })
+	resetElectionTimerLocked()
+}
+
+// grantVote decides whether this node votes for a candidate. Besides the usual
+// Raft rules (one vote per term, the candidate's log must be at least as far
+// as ours), a node that has heard from a master within the election timeout
+// refuses, so a slave that only lost its own connection cannot depose a
+// healthy master.
+func grantVote(req voteRequest) voteResponse {
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+
+	refuse := func(reason string) voteResponse {
+		resp := voteResponse{Term: election.Term, Reason: reason}
+		if isMaster || time.Since(lastMasterContact) < electionTimeout() {
+			resp.Master = masterAddress
+		}
+		return resp
+	}
+
+	switch {
+	case !isVoter(cfg.Advertise):
+		return refuse("this node is not a voting member of the cluster")
+	case !isVoter(req.Candidate):
+		return refuse("candidate is not a voting member of the cluster")
+	case isMaster:
+		return refuse("this node is the master")
+	case time.Since(lastMasterContact) < electionTimeout() && !req.Transfer:
+		return refuse("the master is reachable")
+	case req.Term < election.Term:
+		return refuse("stale term")
+	case !nodeAuthEnabled() && !plausibleTermLocked(req.Term):
+		return refuse("implausible term")
+	case req.Term == election.Term && election.VotedFor != "" && election.VotedFor != req.Candidate:
+		return refuse("already voted in this term")
+	case !upToDate(req.LastTerm, req.LastLSN):
+		return refuse(fmt.Sprintf("candidate is behind: LSN %d of term %d", req.LastLSN, req.LastTerm))
+	}
+
+	if req.PreVote {
+		return voteResponse{Term: election.Term, Granted: true}
+	}
+	election = electionState{Term: req.Term, VotedFor: req.Candidate}
+	if err := saveElectionLocked(); err != nil {
+		slog.Error("Failed to persist election state", "error", err)
+		return refuse("failed to persist vote")
+	}
+	resetElectionTimerLocked()
+	slog.Info("Voted", "candidate", req.Candidate, "term", req.Term)
+	return voteResponse{Term: election.Term, Granted: true}
+}
+
+// upToDate reports whether a log ending at lsn, written in term, holds at
+// least everything this node's log holds. As in Raft, the later term wins and
+// the longer log breaks a tie.
+func upToDate(term, lsn uint64) bool {
+	if last := replicationLog.LastTerm(); term != last {
+		return term > last
+	}
+	return lsn >= replicationLog.LastLSN()
+}
+
+// promoteToMaster makes this node the master of term and tells the other
+// nodes about it. The master API is already registered on every node and
+// starts accepting requests as soon as isMaster is set.
+func promoteToMaster(term uint64) {
+	nodeMu.Lock()
+	if isMaster || election.Term != term {
+		nodeMu.Unlock()
+		return
+	}
+	isMaster = true
+	masterAddress = cfg.Advertise
+	election.Master = cfg.Advertise
+	// Winning the election is itself a confirmation by a majority.
+	lastQuorum = time.Now()
+	if err := saveElectionLocked(); err != nil {
+		slog.Error("Failed to persist election state", "error", err)
+	}
+	nodeMu.Unlock()
+
+	slog.Info("This node has been promoted to master", "term", term, "lsn", replicationLog.LastLSN())
+	go heartbeatRound()
+}
+
+// sendHeartbeats announces this node to its peers several times per election
+// timeout while it is the master. That keeps the slaves from starting an
+// election, tells nodes that missed an election who won it, makes an old
+// master that comes back step down, and confirms the majority leadership()
+// relies on.
+func sendHeartbeats() {
+	ticker := time.NewTicker(electionTimeout() / 5)
+	defer ticker.Stop()
+	for range ticker.C {
+		if isCurrentMaster() && len(otherMembers()) > 0 {
+			heartbeatRound()
+		}
+	}
+}
+
+func heartbeatRound() {
+	// A master that stepped down since the round was started sends nothing.
+	if !isCurrentMaster() {
+		return
+	}
+	started := time.Now()
+	term := currentTerm()
+	lsn := replicationLog.LastLSN()
+	topo := currentTopology()
+	peers := otherMembers()
+
+	// Every member hears from the master, but only voters count toward the
+	// quorum.
+	voters := 0
+	results := make(chan bool, len(peers))
+	for _, peer := range peers {
+		voter := topo.isVoter(peer)
+		if voter {
+			voters++
+		}
+		go func(peer string, voter bool) {
+			results <- announceLeader(peer, leaderAnnouncement{Term: term, Master: cfg.Advertise, LSN: lsn, Topology: &topo}) && voter
+		}(peer, voter)
+	}
+	acks := 1
+	for range peers {
+		if <-results {
+			acks++
+		}
+	}
+
+	nodeMu.Lock()
+	defer nodeMu.Unlock()
+	if acks >= quorum(voters) && isMaster && election.Term == term && started.After(lastQuorum) {
+		// Count from when the round started: a slave may have heard from us
+		// no later than that.
+		lastQuorum = started
+	}
+}
+
+// announceLeader tells peer that this node is its master and reports whether
+// the peer accepted it. A peer that knows a newer term refuses, and this
+// node then steps down.
+func announceLeader(peer string, announcement leaderAnnouncement) bool {
+	client := nodeClient(electionTimeout() / 5)
+	data, err := json.Marshal(announcement)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Post(peer+"/election/leader", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return true
+	}
+
+	var refusal struct {
+		Code   string `json:"code"`
+		Term   uint64 `json:"term"`
+		Master string `json:"master"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&refusal) == nil && refusal.Code == "stale_term" && refusal.Term > announcement.Term {
+		if refusal.Master == "" || !followLeader(refusal.Term, refusal.Master) {
+			observeTerm(refusal.Term)
+		}
+	}
+	return false
+}
+
+// requestTerm returns the term a node sent with a request, or 0. The header
+// is ignored on requests that do not come from another node, so a client
+// cannot force the master to step down.
+func requestTerm(r *http.Request) uint64 {
+	if !fromNode(r) {
+		return 0
+	}
+	term, _ := strconv.ParseUint(r.Header.Get(termHeader), 10, 64)
+	return term
+}
+
+// termOf returns the term of the log entry at lsn, if this node knows it.
+func termOf(lsn uint64) (uint64, bool) {
+	term, err := replicationLog.TermAt(lsn)
+	return term, err == nil
+}
+
+// nodeGet sends a GET request to another node, stamped with this node's term.
+func nodeGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(termHeader, strconv.FormatUint(currentTerm(), 10))
+	return nodeClient(0).Do(req)
+}
+
+func defineElectionRoutes() {
+	internalRoute("/election/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req voteRequest
+		if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if req.Candidate == "" || req.Term == 0 {
+			writeError(w, http.StatusBadRequest, "term and candidate are required", nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, grantVote(req))
+	})
+
+	internalRoute("/election/transfer", handleTransfer)
+
+	internalRoute("/election/leader", func(w http.ResponseWriter, r *http.Request) {
+		var announcement leaderAnnouncement
+		if err := decodeJSON(r.Body, &announcement); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		if !followLeader(announcement.Term, announcement.Master) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":  "announcement rejected",
+				"code":   "stale_term",
+				"term":   currentTerm(),
+				"master": currentMaster(),
+			})
+			return
+		}
+		adoptTopology(announcement.Topology)
+		noteMasterPosition(announcement.LSN, time.Now())
+		writeJSON(w, http.StatusOK, map[string]interface{}{"term": announcement.Term})
+	})
+}
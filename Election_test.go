@@ -0,0 +1,245 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testSelf      = "http://node-a:8083"
+	testCandidate = "http://node-b:8084"
+	testOther     = "http://node-c:8085"
+	testLearner   = "http://node-d:8086"
+)
+
+// withElection sets up this node as a voting slave of a four node cluster,
+// with a log of four entries ending in term 2, and restores the globals
+// afterwards.
+func withElection(t *testing.T, state electionState) {
+	t.Helper()
+	savedCfg, savedTopology, savedLog := cfg, topology, replicationLog
+	savedElection, savedContact, savedMaster, savedAddress := election, lastMasterContact, isMaster, masterAddress
+	t.Cleanup(func() {
+		cfg, topology, replicationLog = savedCfg, savedTopology, savedLog
+		election, lastMasterContact, isMaster, masterAddress = savedElection, savedContact, savedMaster, savedAddress
+	})
+
+	cfg.Advertise = testSelf
+	cfg.DataDir = t.TempDir()
+	cfg.ElectionTimeoutMS = 0
+	topology = Topology{Version: 1}
+	for _, addr := range []string{testSelf, testCandidate, testOther} {
+		topology.add(addr, false)
+	}
+	topology.add(testLearner, true)
+	replicationLog, _ = testLog(t, 4)
+	election = state
+	lastMasterContact = time.Time{}
+	isMaster = false
+	masterAddress = testOther
+}
+
+func TestGrantVote(t *testing.T) {
+	vote := func(term, lastTerm, lastLSN uint64) voteRequest {
+		return voteRequest{Term: term, Candidate: testCandidate, LastTerm: lastTerm, LastLSN: lastLSN}
+	}
+
+	tests := []struct {
+		name      string
+		state     electionState
+		req       voteRequest
+		master    bool
+		nodeAuth  bool
+		contact   time.Duration // how long ago the master was heard from, 0 for never
+		granted   bool
+		reason    string
+		votedFor  string
+		votedTerm uint64
+	}{
+		{
+			name:    "next term",
+			state:   electionState{Term: 3},
+			req:     vote(4, 2, 4),
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:    "current term without a vote",
+			state:   electionState{Term: 4},
+			req:     vote(4, 2, 4),
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:    "same candidate again",
+			state:   electionState{Term: 4, VotedFor: testCandidate},
+			req:     vote(4, 2, 4),
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:   "already voted for another",
+			state:  electionState{Term: 4, VotedFor: testOther},
+			req:    vote(4, 2, 4),
+			reason: "already voted in this term", votedFor: testOther, votedTerm: 4,
+		},
+		{
+			name:    "voted for another in an older term",
+			state:   electionState{Term: 3, VotedFor: testOther},
+			req:     vote(4, 2, 4),
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:   "stale term",
+			state:  electionState{Term: 5},
+			req:    vote(4, 2, 4),
+			reason: "stale term", votedTerm: 5,
+		},
+		{
+			name:   "implausible term",
+			state:  electionState{Term: 3},
+			req:    vote(3+maxTermJump+1, 2, 4),
+			reason: "implausible term", votedTerm: 3,
+		},
+		{
+			name:     "far ahead with node auth",
+			state:    electionState{Term: 3},
+			req:      vote(3+maxTermJump+1, 2, 4),
+			nodeAuth: true,
+			granted:  true, votedFor: testCandidate, votedTerm: 3 + maxTermJump + 1,
+		},
+		{
+			name:   "older last term",
+			state:  electionState{Term: 3},
+			req:    vote(4, 1, 9),
+			reason: "candidate is behind", votedTerm: 3,
+		},
+		{
+			name:   "shorter log",
+			state:  electionState{Term: 3},
+			req:    vote(4, 2, 3),
+			reason: "candidate is behind", votedTerm: 3,
+		},
+		{
+			name:    "newer last term with a shorter log",
+			state:   electionState{Term: 3},
+			req:     vote(4, 3, 1),
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:    "master reachable",
+			state:   electionState{Term: 3},
+			req:     vote(4, 2, 4),
+			contact: time.Second,
+			reason:  "the master is reachable", votedTerm: 3,
+		},
+		{
+			name:    "transfer while the master is reachable",
+			state:   electionState{Term: 3},
+			req:     voteRequest{Term: 4, Candidate: testCandidate, LastTerm: 2, LastLSN: 4, Transfer: true},
+			contact: time.Second,
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:    "master silent",
+			state:   electionState{Term: 3},
+			req:     vote(4, 2, 4),
+			contact: 3 * defaultElectionTimeout,
+			granted: true, votedFor: testCandidate, votedTerm: 4,
+		},
+		{
+			name:   "this node is the master",
+			state:  electionState{Term: 3},
+			req:    vote(4, 2, 4),
+			master: true,
+			reason: "this node is the master", votedTerm: 3,
+		},
+		{
+			name:   "candidate is not a member",
+			state:  electionState{Term: 3},
+			req:    voteRequest{Term: 4, Candidate: "http://stranger:8083", LastTerm: 2, LastLSN: 4},
+			reason: "candidate is not a voting member", votedTerm: 3,
+		},
+		{
+			name:   "candidate is not a voter",
+			state:  electionState{Term: 3},
+			req:    voteRequest{Term: 4, Candidate: testLearner, LastTerm: 2, LastLSN: 4},
+			reason: "candidate is not a voting member", votedTerm: 3,
+		},
+		{
+			name:    "pre-vote",
+			state:   electionState{Term: 3},
+			req:     voteRequest{Term: 4, Candidate: testCandidate, LastTerm: 2, LastLSN: 4, PreVote: true},
+			granted: true, votedTerm: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withElection(t, tt.state)
+			isMaster = tt.master
+			if tt.nodeAuth {
+				cfg.Auth.NodeToken = "node-secret"
+			}
+			if tt.contact > 0 {
+				lastMasterContact = time.Now().Add(-tt.contact)
+			}
+
+			resp := grantVote(tt.req)
+			if resp.Granted != tt.granted {
+				t.Errorf("granted = %v (%s), want %v", resp.Granted, resp.Reason, tt.granted)
+			}
+			if !tt.granted && !strings.Contains(resp.Reason, tt.reason) {
+				t.Errorf("reason = %q, want %q", resp.Reason, tt.reason)
+			}
+			if election.Term != tt.votedTerm || election.VotedFor != tt.votedFor {
+				t.Errorf("election = term %d voted for %q, want term %d voted for %q", election.Term, election.VotedFor, tt.votedTerm, tt.votedFor)
+			}
+		})
+	}
+}
+
+func TestGrantVoteThisNodeNotVoter(t *testing.T) {
+	withElection(t, electionState{Term: 3})
+	cfg.Advertise = testLearner
+	resp := grantVote(voteRequest{Term: 4, Candidate: testCandidate, LastTerm: 2, LastLSN: 4})
+	if resp.Granted || !strings.Contains(resp.Reason, "this node is not a voting member") {
+		t.Errorf("got %+v, want a refusal", resp)
+	}
+}
+
+func TestGrantVotePersists(t *testing.T) {
+	withElection(t, electionState{Term: 3})
+	if resp := grantVote(voteRequest{Term: 4, Candidate: testCandidate, LastTerm: 2, LastLSN: 4}); !resp.Granted {
+		t.Fatalf("vote refused: %s", resp.Reason)
+	}
+	election = electionState{}
+	if err := loadElectionState(); err != nil {
+		t.Fatal(err)
+	}
+	if election.Term != 4 || election.VotedFor != testCandidate {
+		t.Errorf("persisted election = %+v", election)
+	}
+}
+
+func TestFollowLeaderFarAhead(t *testing.T) {
+	// A node that was down for long follows the master of a much newer term,
+	// with or without node auth.
+	withElection(t, electionState{Term: 3})
+	if !followLeader(3+10*maxTermJump, testCandidate) {
+		t.Fatal("followLeader refused the master")
+	}
+	if election.Term != 3+10*maxTermJump || currentMaster() != testCandidate {
+		t.Errorf("election = %+v, master %q", election, currentMaster())
+	}
+
+	observeTerm(3 + 20*maxTermJump)
+	if election.Term != 3+20*maxTermJump {
+		t.Errorf("observeTerm: term = %d, want %d", election.Term, 3+20*maxTermJump)
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	for peers, want := range map[int]int{0: 1, 1: 2, 2: 2, 3: 3, 4: 3, 6: 4} {
+		if got := quorum(peers); got != want {
+			t.Errorf("quorum(%d) = %d, want %d", peers, got, want)
+		}
+	}
+}
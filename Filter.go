@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// ReplicationFilter selects the databases and tables a slave holds. A
+// pattern is either a database ("analytics", "tmp_*") or a table
+// ("shop.orders", "*.audit_*"), with shell-style wildcards. With Include set
+// only what it matches is replicated; Exclude wins over Include.
+//
+// The filter is part of the slave's config. The slave sends it when it
+// registers, the master leaves filtered writes out of what it sends, and the
+// slave checks every entry it receives against it as well. Filtered entries
+// still advance the slave's LSN, as "skip" entries without data.
+type ReplicationFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+func (f ReplicationFilter) active() bool {
+	return len(f.Include) > 0 || len(f.Exclude) > 0
+}
+
+func (f ReplicationFilter) validate() error {
+	for _, p := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		dbPattern, tablePattern, _ := strings.Cut(p, ".")
+		if dbPattern == "" {
+			return fmt.Errorf("pattern %q has no database part", p)
+		}
+		if _, err := path.Match(dbPattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if _, err := path.Match(tablePattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// matchPattern reports whether p matches the database dbname and, for a
+// table pattern, the table. A table pattern never matches a database as a
+// whole (table == "").
+func matchPattern(p, dbname, table string) bool {
+	dbPattern, tablePattern, hasTable := strings.Cut(p, ".")
+	if ok, _ := path.Match(dbPattern, dbname); !ok {
+		return false
+	}
+	if !hasTable {
+		return true
+	}
+	if table == "" {
+		return false
+	}
+	ok, _ := path.Match(tablePattern, table)
+	return ok
+}
+
+// allowsDatabase reports whether database-level writes (createdb, dropdb)
+// for dbname are replicated. A database is needed as soon as any of its
+// tables is included.
+func (f ReplicationFilter) allowsDatabase(dbname string) bool {
+	for _, p := range f.Exclude {
+		if !strings.Contains(p, ".") && matchPattern(p, dbname, "") {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		dbPattern, _, _ := strings.Cut(p, ".")
+		if ok, _ := path.Match(dbPattern, dbname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsTable reports whether writes to dbname.table are replicated.
+func (f ReplicationFilter) allowsTable(dbname, table string) bool {
+	for _, p := range f.Exclude {
+		if matchPattern(p, dbname, table) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if matchPattern(p, dbname, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether task is replicated.
+func (f ReplicationFilter) allows(task ReplicationTask) bool {
+	if !f.active() {
+		return true
+	}
+	if task.Table == "" {
+		return f.allowsDatabase(task.DBName)
+	}
+	return f.allowsTable(task.DBName, task.Table)
+}
+
+// filter returns task, or a skip task in its place if f leaves it out. A
+// transaction keeps the operations f allows.
+func (f ReplicationFilter) filter(task ReplicationTask) ReplicationTask {
+	if task.Operation == "transaction" && f.active() {
+		var kept []ReplicationTask
+		for _, op := range task.Operations {
+			if f.allows(op) {
+				kept = append(kept, op)
+			}
+		}
+		if len(kept) == len(task.Operations) {
+			return task
+		}
+		if len(kept) > 0 {
+			task.Operations = kept
+			return task
+		}
+	} else if f.allows(task) {
+		return task
+	}
+	return ReplicationTask{Operation: "skip", DBName: task.DBName, Table: task.Table, RequestID: task.RequestID}
+}
+
+func (f ReplicationFilter) String() string {
+	if !f.active() {
+		return "everything"
+	}
+	var parts []string
+	if len(f.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(f.Include, ", "))
+	}
+	if len(f.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(f.Exclude, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// parseFilter reads the filter a slave sent with its registration.
+func parseFilter(s string) (ReplicationFilter, error) {
+	var f ReplicationFilter
+	if s == "" {
+		return f, nil
+	}
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		return f, err
+	}
+	return f, f.validate()
+}
+
+func (s *slaveState) filter() ReplicationFilter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replFilter
+}
+
+func (s *slaveState) setFilter(f ReplicationFilter) {
+	s.mu.Lock()
+	changed := f.String() != s.replFilter.String()
+	s.replFilter = f
+	s.mu.Unlock()
+	if changed {
+		slog.Info("Slave replication filter", "slave", s.addr, "filter", f.String())
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplicationFilterAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ReplicationFilter
+		db     string
+		table  string
+		want   bool
+	}{
+		{"no filter", ReplicationFilter{}, "shop", "orders", true},
+		{"no filter database", ReplicationFilter{}, "shop", "", true},
+		{"included database", ReplicationFilter{Include: []string{"shop"}}, "shop", "orders", true},
+		{"other database", ReplicationFilter{Include: []string{"shop"}}, "analytics", "events", false},
+		{"wildcard database", ReplicationFilter{Include: []string{"tmp_*"}}, "tmp_1", "t", true},
+		{"included table", ReplicationFilter{Include: []string{"shop.orders"}}, "shop", "orders", true},
+		{"other table", ReplicationFilter{Include: []string{"shop.orders"}}, "shop", "users", false},
+		{"database of included table", ReplicationFilter{Include: []string{"shop.orders"}}, "shop", "", true},
+		{"wildcard table", ReplicationFilter{Include: []string{"*.audit_*"}}, "crm", "audit_log", true},
+		{"wildcard table mismatch", ReplicationFilter{Include: []string{"*.audit_*"}}, "crm", "log", false},
+		{"excluded database", ReplicationFilter{Exclude: []string{"scratch"}}, "scratch", "", false},
+		{"table of excluded database", ReplicationFilter{Exclude: []string{"scratch"}}, "scratch", "t", false},
+		{"excluded table", ReplicationFilter{Exclude: []string{"shop.sessions"}}, "shop", "sessions", false},
+		{"database of excluded table", ReplicationFilter{Exclude: []string{"shop.sessions"}}, "shop", "", true},
+		{"exclude wins", ReplicationFilter{Include: []string{"shop"}, Exclude: []string{"shop.sessions"}}, "shop", "sessions", false},
+		{"include with exclude", ReplicationFilter{Include: []string{"shop"}, Exclude: []string{"shop.sessions"}}, "shop", "orders", true},
+		{"dot is not a wildcard", ReplicationFilter{Include: []string{"shop"}}, "shopx", "orders", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := ReplicationTask{Operation: "insert", DBName: tt.db, Table: tt.table}
+			if tt.table == "" {
+				task.Operation = "createdb"
+			}
+			if got := tt.filter.allows(task); got != tt.want {
+				t.Errorf("allows(%s.%s) = %v, want %v", tt.db, tt.table, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicationFilterFilter(t *testing.T) {
+	filter := ReplicationFilter{Include: []string{"shop"}}
+	insert := func(db string) ReplicationTask {
+		return ReplicationTask{Operation: "insert", DBName: db, Table: "t", Values: map[string]interface{}{"a": 1}}
+	}
+
+	tests := []struct {
+		name string
+		task ReplicationTask
+		want ReplicationTask
+	}{
+		{"kept", insert("shop"), insert("shop")},
+		{
+			"skipped",
+			ReplicationTask{Operation: "insert", DBName: "crm", Table: "t", Values: map[string]interface{}{"a": 1}, RequestID: "r1"},
+			ReplicationTask{Operation: "skip", DBName: "crm", Table: "t", RequestID: "r1"},
+		},
+		{
+			"transaction kept",
+			ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{insert("shop")}},
+			ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{insert("shop")}},
+		},
+		{
+			"transaction trimmed",
+			ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{insert("crm"), insert("shop")}},
+			ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{insert("shop")}},
+		},
+		{
+			"transaction skipped",
+			ReplicationTask{Operation: "transaction", DBName: "crm", Operations: []ReplicationTask{insert("crm")}},
+			ReplicationTask{Operation: "skip", DBName: "crm"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filter.filter(tt.task); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ReplicationFilter
+		ok   bool
+	}{
+		{"", ReplicationFilter{}, true},
+		{`{"include":["shop","crm.*"]}`, ReplicationFilter{Include: []string{"shop", "crm.*"}}, true},
+		{`{"exclude":[".orders"]}`, ReplicationFilter{}, false},
+		{`{"include":["shop.[a"]}`, ReplicationFilter{}, false},
+		{`not json`, ReplicationFilter{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseFilter(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseFilter(%q) err = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilter(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFilteredSlaveForwardsReads(t *testing.T) {
+	var forwarded string
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = r.URL.Path + " " + string(body)
+		w.Write([]byte("[]"))
+	}))
+	defer master.Close()
+
+	withAuthConfig(t, AuthConfig{})
+	savedMaster, savedAddress := isMaster, masterAddress
+	t.Cleanup(func() { isMaster, masterAddress = savedMaster, savedAddress })
+	isMaster = false
+	cfg.ReplicationFilter = ReplicationFilter{Exclude: []string{"shop.sessions"}}
+	handler := map[string]http.HandlerFunc{"/select": selectRecords, "/databases": listDatabases, "/tables": listTables}
+
+	tests := []struct {
+		name      string
+		master    string
+		path      string
+		body      string
+		status    int
+		forwarded string
+	}{
+		{"excluded table", master.URL, "/select", `{"dbname":"shop","table":"sessions"}`, http.StatusOK, `/select {"dbname":"shop","table":"sessions"}`},
+		{"excluded table by GET", master.URL, "/select?dbname=shop&table=sessions", "", http.StatusOK, "/select "},
+		{"database list", master.URL, "/databases", "", http.StatusOK, "/databases "},
+		{"table list", master.URL, "/tables?dbname=shop", "", http.StatusOK, "/tables "},
+		{"no master", "", "/select", `{"dbname":"shop","table":"sessions"}`, http.StatusServiceUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			masterAddress = tt.master
+			method := http.MethodGet
+			if tt.body != "" {
+				method = http.MethodPost
+			}
+			w := httptest.NewRecorder()
+			handler[strings.SplitN(tt.path, "?", 2)[0]](w, httptest.NewRequest(method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if strings.TrimSpace(forwarded) != strings.TrimSpace(tt.forwarded) {
+				t.Errorf("master got %q, want %q", forwarded, tt.forwarded)
+			}
+		})
+	}
+}
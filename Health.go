@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health states of a node as seen by the failure detector. A node that misses
+// SuspectAfter heartbeats in a row becomes suspect and after DeadAfter it is
+// dead. A dead node that answers again is recovering until it has answered
+// RecoverAfter heartbeats in a row, and only then alive.
+const (
+	healthAlive      = "alive"
+	healthSuspect    = "suspect"
+	healthDead       = "dead"
+	healthRecovering = "recovering"
+)
+
+// FailureDetectorConfig sets how often nodes send each other heartbeats and
+// how many of them decide a state change.
+type FailureDetectorConfig struct {
+	IntervalMS   int `json:"interval_ms"`
+	SuspectAfter int `json:"suspect_after"`
+	DeadAfter    int `json:"dead_after"`
+	RecoverAfter int `json:"recover_after"`
+}
+
+func (fd FailureDetectorConfig) validate() error {
+	if fd.IntervalMS <= 0 || fd.SuspectAfter <= 0 || fd.DeadAfter <= 0 || fd.RecoverAfter <= 0 {
+		return fmt.Errorf("interval_ms, suspect_after, dead_after and recover_after must be positive")
+	}
+	if fd.SuspectAfter > fd.DeadAfter {
+		return fmt.Errorf("suspect_after (%d) must not be larger than dead_after (%d)", fd.SuspectAfter, fd.DeadAfter)
+	}
+	return nil
+}
+
+func (fd FailureDetectorConfig) interval() time.Duration {
+	return time.Duration(fd.IntervalMS) * time.Millisecond
+}
+
+// peerHealth is the failure detector's view of one node.
+type peerHealth struct {
+	mu       sync.Mutex
+	state    string // empty until the first heartbeat
+	missed   int    // consecutive failed heartbeats
+	passed   int    // consecutive answered heartbeats while recovering
+	lastSeen time.Time
+	changed  time.Time
+}
+
+// reset sets the state without a heartbeat, for example when a slave
+// registers and has thereby shown that it is up.
+func (h *peerHealth) reset(state string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = state
+	h.missed = 0
+	h.passed = 0
+	h.changed = time.Now()
+	if state == healthAlive {
+		h.lastSeen = h.changed
+	}
+}
+
+// observe records the outcome of one heartbeat and returns the state before
+// and after it.
+func (h *peerHealth) observe(ok bool, fd FailureDetectorConfig) (from, to string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	from = h.state
+	if ok {
+		h.missed = 0
+		h.lastSeen = time.Now()
+		switch h.state {
+		case healthDead:
+			h.state = healthRecovering
+			h.passed = 1
+		case healthRecovering:
+			h.passed++
+		default:
+			h.state = healthAlive
+		}
+		if h.state == healthRecovering && h.passed >= fd.RecoverAfter {
+			h.state = healthAlive
+		}
+	} else {
+		h.missed++
+		h.passed = 0
+		switch {
+		case h.state == healthRecovering || h.missed >= fd.DeadAfter:
+			// A recovering node that misses a heartbeat is flapping and
+			// starts over.
+			h.state = healthDead
+		case h.missed >= fd.SuspectAfter && h.state != healthDead:
+			h.state = healthSuspect
+		}
+	}
+	if h.state != from {
+		h.changed = time.Now()
+	}
+	return from, h.state
+}
+
+// HealthStatus is a node's state as reported by /status and /cluster/nodes.
+type HealthStatus struct {
+	State    string    `json:"state"`
+	Missed   int       `json:"missed_heartbeats"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	Since    time.Time `json:"since,omitempty"`
+}
+
+func (h *peerHealth) status() HealthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HealthStatus{State: describeHealth(h.state), Missed: h.missed, LastSeen: h.lastSeen, Since: h.changed}
+}
+
+func describeHealth(state string) string {
+	if state == "" {
+		return "unknown"
+	}
+	return state
+}
+
+// masterHealth is a slave's view of its master.
+var masterHealth peerHealth
+
+// startFailureDetector starts the heartbeats in both directions: the master
+// checks every registered slave and a slave checks its master.
+func startFailureDetector() {
+	go probeSlaves()
+	go probeMaster()
+}
+
+func ping(client *http.Client, addr string) bool {
+	resp, err := client.Get(addr + "/ping")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// probeSlaves sends a heartbeat to every registered slave once per interval
+// while this node is the master. Only dead slaves are taken out of the online
+// set that sync writes wait for; a slave that becomes healthy again is put
+// back and its sender resumes at once.
+func probeSlaves() {
+	fd := cfg.FailureDetector
+	client := nodeClient(fd.interval())
+	ticker := time.NewTicker(fd.interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		if !isCurrentMaster() {
+			continue
+		}
+		var wg sync.WaitGroup
+		rangeSlaves(func(s *slaveState) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.heartbeat(client, fd)
+			}()
+		})
+		wg.Wait()
+	}
+}
+
+func (s *slaveState) heartbeat(client *http.Client, fd FailureDetectorConfig) {
+	from, to := s.health.observe(ping(client, s.addr), fd)
+	if from == to {
+		return
+	}
+	slog.Warn("Slave health changed", "slave", s.addr, "state", to, "was", describeHealth(from))
+	countHealthChange(to)
+	switch to {
+	case healthAlive:
+		s.setOnline(true)
+	case healthDead:
+		s.setOnline(false)
+	case healthRecovering:
+		// Let the slave catch up while it proves itself.
+		s.nudge()
+	}
+}
+
+// probeMaster sends a heartbeat to the master once per interval while this
+// node is a slave. Failover itself is driven by the election timeout; this
+// view is reported on /status and the dashboard.
+func probeMaster() {
+	fd := cfg.FailureDetector
+	client := nodeClient(fd.interval())
+	ticker := time.NewTicker(fd.interval())
+	defer ticker.Stop()
+	watched := ""
+	for range ticker.C {
+		master := currentMaster()
+		if isCurrentMaster() || master == "" {
+			watched = ""
+			continue
+		}
+		if master != watched {
+			masterHealth.reset("")
+			watched = master
+		}
+		if from, to := masterHealth.observe(ping(client, master), fd); from != to {
+			slog.Warn("Master health changed", "master", master, "state", to, "was", describeHealth(from))
+			countHealthChange(to)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPeerHealthObserve(t *testing.T) {
+	fd := FailureDetectorConfig{IntervalMS: 100, SuspectAfter: 2, DeadAfter: 4, RecoverAfter: 3}
+
+	// Each heartbeat is "+" (answered) or "-" (missed); states lists the
+	// state after every heartbeat.
+	tests := []struct {
+		name       string
+		heartbeats string
+		states     string
+	}{
+		{"first answer", "+", "alive"},
+		{"first miss", "-", ""},
+		{"one miss", "+-", "alive alive"},
+		{"suspect", "+--", "alive alive suspect"},
+		{"suspect and back", "+--+", "alive alive suspect alive"},
+		{"dead", "+----", "alive alive suspect suspect dead"},
+		{"dead without answering first", "----", " suspect suspect dead"},
+		{"stays dead", "+-----", "alive alive suspect suspect dead dead"},
+		{"recovering", "+----++", "alive alive suspect suspect dead recovering recovering"},
+		{"recovered", "+----+++", "alive alive suspect suspect dead recovering recovering alive"},
+		{"flapping", "+----++-", "alive alive suspect suspect dead recovering recovering dead"},
+		{"flapping starts over", "+----++-+++", "alive alive suspect suspect dead recovering recovering dead recovering recovering alive"},
+		{"misses reset by an answer", "+-+-+-", "alive alive alive alive alive alive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h peerHealth
+			var states []string
+			for _, beat := range tt.heartbeats {
+				_, to := h.observe(beat == '+', fd)
+				states = append(states, to)
+			}
+			if got := strings.Join(states, " "); got != tt.states {
+				t.Errorf("states = %q, want %q", got, tt.states)
+			}
+		})
+	}
+}
+
+func TestPeerHealthObserveReportsChange(t *testing.T) {
+	fd := FailureDetectorConfig{IntervalMS: 100, SuspectAfter: 1, DeadAfter: 1, RecoverAfter: 1}
+	var h peerHealth
+	h.reset(healthAlive)
+	if from, to := h.observe(false, fd); from != healthAlive || to != healthDead {
+		t.Errorf("observe(miss) = %s -> %s, want alive -> dead", from, to)
+	}
+	if from, to := h.observe(true, fd); from != healthDead || to != healthAlive {
+		t.Errorf("observe(answer) = %s -> %s, want dead -> alive", from, to)
+	}
+	if status := h.status(); status.State != healthAlive || status.Missed != 0 || status.LastSeen.IsZero() {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestFailureDetectorConfigValidate(t *testing.T) {
+	tests := []struct {
+		fd FailureDetectorConfig
+		ok bool
+	}{
+		{FailureDetectorConfig{IntervalMS: 1000, SuspectAfter: 2, DeadAfter: 5, RecoverAfter: 3}, true},
+		{FailureDetectorConfig{IntervalMS: 1000, SuspectAfter: 5, DeadAfter: 5, RecoverAfter: 1}, true},
+		{FailureDetectorConfig{IntervalMS: 0, SuspectAfter: 2, DeadAfter: 5, RecoverAfter: 3}, false},
+		{FailureDetectorConfig{IntervalMS: 1000, SuspectAfter: 6, DeadAfter: 5, RecoverAfter: 3}, false},
+		{FailureDetectorConfig{IntervalMS: 1000, SuspectAfter: 2, DeadAfter: 5, RecoverAfter: 0}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.fd.validate(); (err == nil) != tt.ok {
+			t.Errorf("validate(%+v) = %v, want ok %v", tt.fd, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries a request's ID. A client may choose it; otherwise
+// the node that receives the request assigns one. The master stores it in the
+// ReplicationTask and sends it along with the log entry, so the same ID shows
+// up in the logs of the client, the master and every slave.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// setupLogging makes a leveled slog logger the default. Output of the
+// standard log package goes through it as well.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log_level %q: must be debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log_format %q: must be json or text", format)
+	}
+	slog.SetDefault(slog.New(handler).With("node", cfg.Advertise))
+	return nil
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs chosen by clients as long as they are short and
+// cannot break a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return strings.IndexFunc(id, func(r rune) bool { return r <= ' ' || r > '~' }) < 0
+}
+
+// requestID returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID gives every request an ID, returns it in the response and
+// logs the request once it has been served.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		started := time.Now()
+		h.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		level := slog.LevelDebug
+		if rec.code >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "request served",
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.code,
+			"duration_ms", time.Since(started).Milliseconds(),
+			"remote", r.RemoteAddr)
+	})
+}
+
+// taskAttrs are the log attributes that identify a replicated write.
+func taskAttrs(lsn uint64, task ReplicationTask) []any {
+	return []any{
+		"request_id", task.RequestID,
+		"lsn", lsn,
+		"operation", task.Operation,
+		"db", task.DBName,
+		"table", task.Table,
+	}
+}
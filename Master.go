@@ -1,70 +1,39 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
-	"os"
-	"os/exec"
-	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	_ "github.com/go-sql-driver/mysql"
 )
 
 var (
-	db                 *sql.DB
-	slaveAddresses            = []string{"http://localhost:8084", "http://localhost:8085"}
-	isMaster           bool   = true
-	masterAddress      string = "http://localhost:8083"
-	electionInProgress bool
-	slaveConnections   sync.Map
-	replicationQueue   = make(chan ReplicationTask, 1000)
+	slaveConnections sync.Map
+	// writeMu serializes writes on the master so that the order of entries in
+	// the replication log matches the order in which MySQL committed them.
+	writeMu sync.Mutex
 )
 
-type ReplicationTask struct {
-	Operation string
-	Data      map[string]interface{}
-}
-
-func allowCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-}
-
-func clearScreen() {
-	if runtime.GOOS == "windows" {
-		cmd := exec.Command("cmd", "/c", "cls")
-		cmd.Stdout = os.Stdout
-		cmd.Run()
-	} else {
-		fmt.Print("\033[H\033[2J")
-	}
-}
-
-func printDashboard() {
+func printMasterDashboard() {
 	clearScreen()
 	fmt.Println("╔════════════════════════════════════════════════════════════╗")
 	fmt.Println("║                    MASTER SERVER DASHBOARD                  ║")
 	fmt.Println("╠════════════════════════════════════════════════════════════╣")
-	fmt.Println("║ Status: Running on port 8083                               ║")
+	fmt.Printf("║ Status: Running on %-40s║\n", cfg.Listen)
 	fmt.Println("║                                                            ║")
 	fmt.Println("║ Connected Slaves:                                          ║")
-	slaveConnections.Range(func(key, value interface{}) bool {
-		addr := key.(string)
-		status := value.(bool)
+	rangeSlaves(func(s *slaveState) {
 		statusStr := "❌ Offline"
-		if status {
+		if s.isOnline() {
 			statusStr = "✅ Online"
 		}
-		fmt.Printf("║   - %s: %s\n", addr, statusStr)
-		return true
+		fmt.Printf("║   - %s: %s (%s)\n", s.addr, statusStr, s.health.status().State)
 	})
 	fmt.Println("║                                                            ║")
 	fmt.Println("║ Available Commands:                                        ║")
@@ -77,729 +46,444 @@ func printDashboard() {
 	fmt.Println("║   7. Delete Record                                         ║")
 	fmt.Println("║   8. Show Replication Status                               ║")
 	fmt.Println("║   9. Refresh Dashboard                                     ║")
+	fmt.Println("║  10. Switch Over to a Slave                                ║")
+	fmt.Println("║  11. Check Slave Consistency                               ║")
 	fmt.Println("║   0. Exit                                                  ║")
 	fmt.Println("╚════════════════════════════════════════════════════════════╝")
 	fmt.Print("\nEnter command number: ")
 }
 
-func main() {
-	var err error
-	db, err = sql.Open("mysql", "root:k7l15981@tcp(127.0.0.1:3306)/")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
+// defineMasterRoutes registers the master API. The handlers only serve
+// requests while this node is the master.
+func defineMasterRoutes() {
+	internalRoute("/register-slave", masterOnly(registerSlave))
+	internalRoute("/replication/log", masterOnly(streamLog))
+	internalRoute("/replication/snapshot", masterOnly(serveSnapshot))
+	http.HandleFunc("/replication/status", requireRole(roleReadOnly, masterOnly(replicationStatusHandler)))
+	http.HandleFunc("/admin/checksum", requireRole(roleAdmin, masterOnly(checksumHandler)))
+
+	http.HandleFunc("/createdb", masterOnly(createDB))
+	http.HandleFunc("/dropdb", masterOnly(dropDB))
+	http.HandleFunc("/createtable", masterOnly(createTable))
+	http.HandleFunc("/insert", masterOnly(insertRecord))
+	http.HandleFunc("/update", masterOnly(updateRecord))
+	http.HandleFunc("/delete", masterOnly(deleteRecord))
+	http.HandleFunc("/transaction", masterOnly(transaction))
+	http.HandleFunc("/bulkinsert", masterOnly(bulkInsert))
+	http.HandleFunc("/admin/switchover", requireRole(roleAdmin, masterOnly(switchoverHandler)))
+}
 
-	err = db.Ping()
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+// masterOnly rejects requests while this node is not the master and tells
+// the client where the master is. A node sending a newer term than ours
+// means this master has been replaced, so it steps down first.
+func masterOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if term := requestTerm(r); term > currentTerm() {
+			observeTerm(term)
+		}
+		if !isCurrentMaster() {
+			writeNotMaster(w, errNotMaster)
+			return
+		}
+		h(w, r)
 	}
+}
 
-	// Start replication worker
-	go replicationWorker()
-
-	// Start slave health check
-	startSlaveHealthCheck()
-
-	// Start HTTP server in a goroutine
-	go func() {
-		http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("pong"))
-		})
-
-		http.HandleFunc("/register-slave", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			slaveAddr := r.URL.Query().Get("address")
-			if slaveAddr != "" {
-				slaveConnections.Store(slaveAddr, true)
-				w.WriteHeader(http.StatusOK)
-				json.NewEncoder(w).Encode(map[string]string{"status": "registered"})
-			}
-		})
-
-		http.HandleFunc("/createdb", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			createDB(w, r)
-		})
-
-		http.HandleFunc("/dropdb", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			dropDB(w, r)
-		})
-
-		http.HandleFunc("/createtable", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			createTable(w, r)
-		})
-
-		http.HandleFunc("/insert", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			insertRecord(w, r)
-		})
-
-		http.HandleFunc("/select", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			selectRecords(w, r)
-		})
-
-		http.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			updateRecord(w, r)
-		})
-
-		http.HandleFunc("/delete", func(w http.ResponseWriter, r *http.Request) {
-			allowCORS(w)
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-			deleteRecord(w, r)
-		})
-
-		fmt.Println("Master server running on port 8083...")
-		log.Fatal(http.ListenAndServe(":8083", nil))
-	}()
+// writeNotMaster answers a request this node cannot serve because it is not,
+// or cannot act as, the master.
+func writeNotMaster(w http.ResponseWriter, err error) {
+	code := "not_master"
+	if err == errNoQuorum {
+		code = "no_quorum"
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+		"error":  err.Error(),
+		"code":   code,
+		"master": currentMaster(),
+		"term":   currentTerm(),
+	})
+}
 
-	// Start dashboard
+// runMasterDashboard runs the master dashboard until the user exits, which
+// it reports by returning true, or until this node stops being the master.
+func runMasterDashboard() bool {
 	for {
-		printDashboard()
-		var choice string
-		fmt.Scanln(&choice)
+		if !isCurrentMaster() {
+			return false
+		}
+		printMasterDashboard()
+		choice := prompt("")
 
 		switch choice {
 		case "1":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			_, err := db.Exec("CREATE DATABASE IF NOT EXISTS " + dbname)
+			dbname := prompt("Enter database name: ")
+			_, err := dashboardWrite(ReplicationTask{Operation: "createdb", DBName: dbname})
 			if err != nil {
 				fmt.Println("Error creating database:", err)
 			} else {
 				fmt.Println("Database created successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "createdb",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-					},
-				}
 			}
 		case "2":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			_, err := db.Exec("DROP DATABASE IF EXISTS " + dbname)
+			dbname := prompt("Enter database name: ")
+			_, err := dashboardWrite(ReplicationTask{Operation: "dropdb", DBName: dbname})
 			if err != nil {
 				fmt.Println("Error dropping database:", err)
 			} else {
 				fmt.Println("Database dropped successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "dropdb",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-					},
-				}
 			}
 		case "3":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			fmt.Print("Enter table name: ")
-			var table string
-			fmt.Scanln(&table)
-			fmt.Print("Enter schema (e.g., id INT, name VARCHAR(255)): ")
-			var schema string
-			fmt.Scanln(&schema)
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			columns, err := parseColumnDefs(prompt("Enter columns (e.g., id INT PRIMARY KEY, name VARCHAR(255) NOT NULL): "))
+			if err != nil {
+				fmt.Println("Error reading columns:", err)
+				break
+			}
 
-			query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s)", dbname, table, schema)
-			_, err := db.Exec(query)
+			_, err = dashboardWrite(ReplicationTask{Operation: "createtable", DBName: dbname, Table: table, Columns: columns})
 			if err != nil {
 				fmt.Println("Error creating table:", err)
 			} else {
 				fmt.Println("Table created successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "createtable",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-						"table":  table,
-						"schema": schema,
-					},
-				}
 			}
 		case "4":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			fmt.Print("Enter table name: ")
-			var table string
-			fmt.Scanln(&table)
-			fmt.Print("Enter values (e.g., 1, 'John'): ")
-			var values string
-			fmt.Scanln(&values)
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			values, err := parseAssignments(prompt("Enter values (e.g., id=1, name='John'): "))
+			if err != nil {
+				fmt.Println("Error reading values:", err)
+				break
+			}
 
-			query := fmt.Sprintf("INSERT INTO %s.%s VALUES (%s)", dbname, table, values)
-			_, err := db.Exec(query)
+			_, err = dashboardWrite(ReplicationTask{Operation: "insert", DBName: dbname, Table: table, Values: values})
 			if err != nil {
 				fmt.Println("Error inserting record:", err)
 			} else {
 				fmt.Println("Record inserted successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "insert",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-						"table":  table,
-						"values": values,
-					},
-				}
 			}
 		case "5":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			fmt.Print("Enter table name: ")
-			var table string
-			fmt.Scanln(&table)
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
 
-			query := fmt.Sprintf("SELECT * FROM %s.%s", dbname, table)
-			rows, err := db.Query(query)
+			query, args, err := buildSelect(SelectQuery{DBName: dbname, Table: table})
 			if err != nil {
 				fmt.Println("Error selecting records:", err)
-				continue
+				break
 			}
-			defer rows.Close()
-
-			cols, err := rows.Columns()
+			printQuery(query, args...)
+		case "6":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			set, err := parseAssignments(prompt("Enter new values (e.g., name='John'): "))
 			if err != nil {
-				fmt.Println("Error getting columns:", err)
-				continue
-			}
-
-			// Print column headers
-			for _, col := range cols {
-				fmt.Printf("%-15s", col)
+				fmt.Println("Error reading values:", err)
+				break
 			}
-			fmt.Println("\n" + strings.Repeat("-", len(cols)*15))
-
-			// Print rows
-			for rows.Next() {
-				columns := make([]interface{}, len(cols))
-				columnPointers := make([]interface{}, len(cols))
-				for i := range columns {
-					columnPointers[i] = &columns[i]
-				}
-
-				if err := rows.Scan(columnPointers...); err != nil {
-					fmt.Println("Error scanning row:", err)
-					continue
-				}
-
-				for i := range cols {
-					val := columnPointers[i].(*interface{})
-					fmt.Printf("%-15v", *val)
-				}
-				fmt.Println()
+			where, err := parseConditions(prompt("Enter conditions (e.g., id=1): "))
+			if err != nil {
+				fmt.Println("Error reading conditions:", err)
+				break
 			}
-		case "6":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			fmt.Print("Enter table name: ")
-			var table string
-			fmt.Scanln(&table)
-			fmt.Print("Enter SET clause (e.g., name='John'): ")
-			var set string
-			fmt.Scanln(&set)
-			fmt.Print("Enter WHERE clause (e.g., id=1): ")
-			var where string
-			fmt.Scanln(&where)
 
-			query := fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s", dbname, table, set, where)
-			_, err := db.Exec(query)
+			_, err = dashboardWrite(ReplicationTask{Operation: "update", DBName: dbname, Table: table, Set: set, Where: where})
 			if err != nil {
 				fmt.Println("Error updating record:", err)
 			} else {
 				fmt.Println("Record updated successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "update",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-						"table":  table,
-						"set":    set,
-						"where":  where,
-					},
-				}
 			}
 		case "7":
-			fmt.Print("Enter database name: ")
-			var dbname string
-			fmt.Scanln(&dbname)
-			fmt.Print("Enter table name: ")
-			var table string
-			fmt.Scanln(&table)
-			fmt.Print("Enter WHERE clause (e.g., id=1): ")
-			var where string
-			fmt.Scanln(&where)
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			where, err := parseConditions(prompt("Enter conditions (e.g., id=1): "))
+			if err != nil {
+				fmt.Println("Error reading conditions:", err)
+				break
+			}
 
-			query := fmt.Sprintf("DELETE FROM %s.%s WHERE %s", dbname, table, where)
-			_, err := db.Exec(query)
+			_, err = dashboardWrite(ReplicationTask{Operation: "delete", DBName: dbname, Table: table, Where: where})
 			if err != nil {
 				fmt.Println("Error deleting record:", err)
 			} else {
 				fmt.Println("Record deleted successfully")
-				replicationQueue <- ReplicationTask{
-					Operation: "delete",
-					Data: map[string]interface{}{
-						"dbname": dbname,
-						"table":  table,
-						"where":  where,
-					},
-				}
 			}
 		case "8":
-			fmt.Println("\nReplication Status:")
-			fmt.Println("------------------")
-			slaveConnections.Range(func(key, value interface{}) bool {
-				addr := key.(string)
-				status := value.(bool)
-				statusStr := "Offline"
-				if status {
-					statusStr = "Online"
-				}
-				fmt.Printf("Slave %s: %s\n", addr, statusStr)
-				return true
-			})
+			printReplicationStatus()
 		case "9":
 			continue
+		case "10":
+			target := prompt("Enter slave address (e.g., http://localhost:8084): ")
+			fmt.Println("Pausing writes and waiting for the slave to catch up...")
+			if err := switchover(target, defaultSwitchoverTimeout); err != nil {
+				fmt.Println("Error switching over:", err)
+			} else {
+				fmt.Printf("%s is the new master; this node is now a slave\n", target)
+			}
+		case "11":
+			opts := CheckOptions{DBName: prompt("Enter database name (empty for all): ")}
+			if opts.DBName != "" {
+				opts.Table = prompt("Enter table name (empty for all): ")
+			}
+			opts.Repair = strings.EqualFold(prompt("Repair differing chunks from the master? (y/N): "), "y")
+			fmt.Println("Comparing checksums with the slaves...")
+			report, err := checkConsistency(context.Background(), opts)
+			if err != nil {
+				fmt.Println("Error checking consistency:", err)
+			} else {
+				printConsistencyReport(report)
+			}
 		case "0":
 			fmt.Println("Exiting...")
-			return
+			return true
 		default:
-			fmt.Println("Invalid choice. Press Enter to continue...")
-			fmt.Scanln()
+			prompt("Invalid choice. Press Enter to continue...")
 		}
 
-		fmt.Println("\nPress Enter to continue...")
-		fmt.Scanln()
+		prompt("\nPress Enter to continue...")
 	}
 }
 
-func replicationWorker() {
-	for task := range replicationQueue {
-		slaveConnections.Range(func(key, value interface{}) bool {
-			addr := key.(string)
-			status := value.(bool)
-			if status {
-				// Check if slave is still alive before replicating
-				resp, err := http.Get(addr + "/ping")
-				if err != nil || resp.StatusCode != http.StatusOK {
-					slaveConnections.Store(addr, false)
-					return true
-				}
-				resp.Body.Close()
-				go replicateToSlave(addr, task)
-			}
-			return true
-		})
-	}
-}
+// commitWrite executes a write on the master database and appends it to the
+// replication log, stamped with the current term and with the ids MySQL
+// generated for the rows it inserted. It fails with errNotMaster or
+// errNoQuorum when this node may not write.
+func commitWrite(task ReplicationTask) (LogEntry, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
 
-func replicateToSlave(slaveAddr string, task ReplicationTask) {
-	client := &http.Client{Timeout: 5 * time.Second}
-
-	switch task.Operation {
-	case "createdb":
-		_, err := client.Get(fmt.Sprintf("%s/replicate/db?name=%s", slaveAddr, task.Data["dbname"]))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
-	case "dropdb":
-		_, err := client.Get(fmt.Sprintf("%s/replicate/dropdb?name=%s", slaveAddr, task.Data["dbname"]))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
-	case "createtable":
-		_, err := client.Get(fmt.Sprintf("%s/replicate/table?dbname=%s&table=%s&schema=%s",
-			slaveAddr, task.Data["dbname"], task.Data["table"], url.QueryEscape(task.Data["schema"].(string))))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
-	case "insert":
-		jsonData, _ := json.Marshal(task.Data)
-		_, err := client.Post(slaveAddr+"/replicate/insert", "application/json", strings.NewReader(string(jsonData)))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
-	case "update":
-		jsonData, _ := json.Marshal(task.Data)
-		_, err := client.Post(slaveAddr+"/replicate/update", "application/json", strings.NewReader(string(jsonData)))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
-	case "delete":
-		jsonData, _ := json.Marshal(task.Data)
-		_, err := client.Post(slaveAddr+"/replicate/delete", "application/json", strings.NewReader(string(jsonData)))
-		if err != nil {
-			slaveConnections.Store(slaveAddr, false)
-		}
+	term, err := leadership()
+	if err != nil {
+		return LogEntry{}, err
 	}
-}
-
-func createDB(w http.ResponseWriter, r *http.Request) {
-	dbname := r.URL.Query().Get("name")
-	if dbname == "" {
-		http.Error(w, "Database name is required", http.StatusBadRequest)
-		return
+	if mysqlAhead() {
+		return LogEntry{}, errStateAhead
+	}
+	if err := replayLog(); err != nil {
+		return LogEntry{}, fmt.Errorf("MySQL is behind the replication log: %w", err)
+	}
+	if isSchemaChange(task.Operation) {
+		return commitSchemaChange(term, task)
 	}
 
-	_, err := db.Exec("CREATE DATABASE IF NOT EXISTS " + dbname)
+	tx, err := db.BeginTx(context.Background(), nil)
 	if err != nil {
-		http.Error(w, "Failed to create database: "+err.Error(), http.StatusInternalServerError)
-		return
+		countMySQLError(task.Operation)
+		return LogEntry{}, err
 	}
-
-	replicationQueue <- ReplicationTask{
-		Operation: "createdb",
-		Data: map[string]interface{}{
-			"dbname": dbname,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Database created successfully"})
-}
-
-func dropDB(w http.ResponseWriter, r *http.Request) {
-	dbname := r.URL.Query().Get("name")
-	if dbname == "" {
-		http.Error(w, "Database name is required", http.StatusBadRequest)
-		return
+	defer tx.Rollback()
+	task, err = applyAssigningIDs(tx, task)
+	if err != nil {
+		return LogEntry{}, err
 	}
-
-	_, err := db.Exec("DROP DATABASE IF EXISTS " + dbname)
+	// The entry is logged before the transaction commits. If the node stops
+	// in between, the write is replayed from the log on restart, so a write
+	// MySQL committed is never missing from the log.
+	entry, err := replicationLog.Append(term, task)
 	if err != nil {
-		http.Error(w, "Failed to drop database: "+err.Error(), http.StatusInternalServerError)
-		return
+		return LogEntry{}, fmt.Errorf("appending to replication log: %w", err)
 	}
-
-	replicationQueue <- ReplicationTask{
-		Operation: "dropdb",
-		Data: map[string]interface{}{
-			"dbname": dbname,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Database dropped successfully"})
-}
-
-func createTable(w http.ResponseWriter, r *http.Request) {
-	dbname := r.URL.Query().Get("dbname")
-	table := r.URL.Query().Get("table")
-	schema := r.URL.Query().Get("schema")
-
-	if dbname == "" || table == "" || schema == "" {
-		http.Error(w, "All parameters (dbname, table, schema) are required", http.StatusBadRequest)
-		return
+	if err = recordApplied(tx, entry.LSN, entry.Term); err == nil {
+		err = tx.Commit()
 	}
-
-	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s (%s)", dbname, table, schema)
-	_, err := db.Exec(query)
 	if err != nil {
-		http.Error(w, "Failed to create table: "+err.Error(), http.StatusInternalServerError)
-		return
+		countMySQLError(task.Operation)
+		tx.Rollback()
+		slog.Error("Write logged but not committed, replaying it", append(taskAttrs(entry.LSN, task), "error", err)...)
+		markReplayNeeded()
+		if err := replayLog(); err != nil {
+			return LogEntry{}, fmt.Errorf("write logged but not committed: %w", err)
+		}
 	}
-
-	replicationQueue <- ReplicationTask{
-		Operation: "createtable",
-		Data: map[string]interface{}{
-			"dbname": dbname,
-			"table":  table,
-			"schema": schema,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Table created successfully"})
+	slog.Info("Write committed", taskAttrs(entry.LSN, task)...)
+	return entry, nil
 }
 
-func insertRecord(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		DBName string `json:"dbname"`
-		Table  string `json:"table"`
-		Values string `json:"values"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+// commitSchemaChange commits a write that MySQL commits implicitly, so it
+// cannot share a transaction with the state row. The entry is stored as
+// pending first; if the node stops before it is logged, the restart finishes
+// it.
+func commitSchemaChange(term uint64, task ReplicationTask) (LogEntry, error) {
+	entry := LogEntry{LSN: replicationLog.LastLSN() + 1, Term: term, Time: time.Now().UTC(), Task: task}
+	if err := recordPending(&entry); err != nil {
+		return LogEntry{}, err
+	}
+	if _, err := applyTask(db, task); err != nil {
+		if err := recordPending(nil); err != nil {
+			markReplayNeeded()
+		}
+		return LogEntry{}, err
 	}
-
-	if req.DBName == "" || req.Table == "" || req.Values == "" {
-		http.Error(w, "All fields (dbname, table, values) are required", http.StatusBadRequest)
-		return
+	if err := replicationLog.AppendEntry(entry); err != nil {
+		markReplayNeeded()
+		return LogEntry{}, fmt.Errorf("appending to replication log: %w", err)
 	}
-
-	query := fmt.Sprintf("INSERT INTO %s.%s VALUES (%s)", req.DBName, req.Table, req.Values)
-	_, err := db.Exec(query)
-	if err != nil {
-		http.Error(w, "Failed to insert record: "+err.Error(), http.StatusInternalServerError)
-		return
+	if err := recordApplied(db, entry.LSN, entry.Term); err != nil {
+		// The change is logged; the next write replays it, which is
+		// harmless for schema changes.
+		markReplayNeeded()
 	}
-
-	replicationQueue <- ReplicationTask{
-		Operation: "insert",
-		Data: map[string]interface{}{
-			"dbname": req.DBName,
-			"table":  req.Table,
-			"values": req.Values,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Record inserted successfully"})
+	slog.Info("Write committed", taskAttrs(entry.LSN, task)...)
+	return entry, nil
 }
 
-func selectRecords(w http.ResponseWriter, r *http.Request) {
-	dbname := r.URL.Query().Get("dbname")
-	table := r.URL.Query().Get("table")
-
-	if dbname == "" || table == "" {
-		http.Error(w, "Both dbname and table parameters are required", http.StatusBadRequest)
-		return
-	}
-
-	query := fmt.Sprintf("SELECT * FROM %s.%s", dbname, table)
-	rows, err := db.Query(query)
+// dashboardWrite commits a write entered on the dashboard and waits for the
+// configured write concern. A concern that is not met only produces a
+// warning, because the write itself has already been committed.
+func dashboardWrite(task ReplicationTask) (LogEntry, error) {
+	task.RequestID = newRequestID()
+	wc, err := effectiveConcern(task, nil)
 	if err != nil {
-		http.Error(w, "Failed to query records: "+err.Error(), http.StatusInternalServerError)
-		return
+		return LogEntry{}, err
 	}
-	defer rows.Close()
-
-	cols, err := rows.Columns()
+	entry, err := commitWrite(task)
 	if err != nil {
-		http.Error(w, "Failed to get columns: "+err.Error(), http.StatusInternalServerError)
-		return
+		return entry, err
 	}
+	if _, err := waitForConcern(entry, wc); err != nil {
+		fmt.Println("Warning:", err)
+	}
+	return entry, nil
+}
 
-	// Get column types
-	colTypes, err := rows.ColumnTypes()
+// streamLog writes the log entries after the requested LSN as newline
+// delimited JSON. Slaves use it to catch up after they were offline.
+func streamLog(w http.ResponseWriter, r *http.Request) {
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
 	if err != nil {
-		http.Error(w, "Failed to get column types: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Parameter after must be an LSN", http.StatusBadRequest)
 		return
 	}
-
-	var results []map[string]interface{}
-	for rows.Next() {
-		columns := make([]interface{}, len(cols))
-		columnPointers := make([]interface{}, len(cols))
-		for i := range columns {
-			columnPointers[i] = &columns[i]
-		}
-
-		if err := rows.Scan(columnPointers...); err != nil {
-			http.Error(w, "Failed to scan row: "+err.Error(), http.StatusInternalServerError)
+	limit := 1000
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Parameter limit must be a positive number", http.StatusBadRequest)
 			return
 		}
-
-		row := make(map[string]interface{})
-		for i, col := range cols {
-			val := columnPointers[i].(*interface{})
-			if *val == nil {
-				row[col] = nil
-				continue
-			}
-
-			switch colTypes[i].DatabaseTypeName() {
-			case "INT", "BIGINT", "TINYINT", "SMALLINT", "MEDIUMINT":
-				if b, ok := (*val).([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = *val
-				}
-			case "DECIMAL", "FLOAT", "DOUBLE":
-				if b, ok := (*val).([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = *val
-				}
-			case "DATETIME", "TIMESTAMP", "DATE", "TIME":
-				if b, ok := (*val).([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = *val
-				}
-			case "VARCHAR", "CHAR", "TEXT", "LONGTEXT", "MEDIUMTEXT", "TINYTEXT":
-				if b, ok := (*val).([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = *val
-				}
-			default:
-				if b, ok := (*val).([]byte); ok {
-					row[col] = string(b)
-				} else {
-					row[col] = *val
-				}
-			}
-		}
-		results = append(results, row)
 	}
 
-	if err := rows.Err(); err != nil {
-		http.Error(w, "Error during rows iteration: "+err.Error(), http.StatusInternalServerError)
+	entries, err := replicationLog.Entries(after, limit, entryBatchBytes)
+	if err == errLogCompacted {
+		http.Error(w, "Entries are no longer in the log, load a snapshot", http.StatusGone)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
-}
-
-func updateRecord(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		DBName string `json:"dbname"`
-		Table  string `json:"table"`
-		Set    string `json:"set"`
-		Where  string `json:"where"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err != nil {
+		http.Error(w, "Failed to read replication log: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if req.DBName == "" || req.Table == "" || req.Set == "" || req.Where == "" {
-		http.Error(w, "All fields (dbname, table, set, where) are required", http.StatusBadRequest)
-		return
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(lastLSNHeader, strconv.FormatUint(replicationLog.LastLSN(), 10))
+	if term, ok := termOf(after); ok {
+		w.Header().Set(prevTermHeader, strconv.FormatUint(term, 10))
 	}
-
-	query := fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s", req.DBName, req.Table, req.Set, req.Where)
-	_, err := db.Exec(query)
-	if err != nil {
-		http.Error(w, "Failed to update record: "+err.Error(), http.StatusInternalServerError)
-		return
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return
+		}
 	}
-
-	replicationQueue <- ReplicationTask{
-		Operation: "update",
-		Data: map[string]interface{}{
-			"dbname": req.DBName,
-			"table":  req.Table,
-			"set":    req.Set,
-			"where":  req.Where,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Record updated successfully"})
 }
 
-func deleteRecord(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		DBName string `json:"dbname"`
-		Table  string `json:"table"`
-		Where  string `json:"where"`
+// writeHandler decodes a structured write from the request body, commits it
+// and replies with message and the commit LSN once the write concern is
+// satisfied. Database creation and removal also accept the database name as
+// the "name" query parameter.
+func writeHandler(operation, message string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ReplicationTask
+			WriteConcern *WriteConcern `json:"write_concern"`
+		}
+		if name := r.URL.Query().Get("name"); name != "" && (operation == "createdb" || operation == "dropdb") {
+			req.DBName = name
+		} else if err := decodeJSON(r.Body, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		task := req.ReplicationTask
+		task.Operation = operation
+		commitRequest(w, r, task, req.WriteConcern, message)
 	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+// commitRequest commits a write made through the API, after checking that
+// the caller may make it, and replies with message and the commit LSN once
+// the write concern is satisfied.
+func commitRequest(w http.ResponseWriter, r *http.Request, task ReplicationTask, requested *WriteConcern, message string) {
+	task.RequestID = requestID(r.Context())
+	if !authorizedTask(w, r, task) {
 		return
 	}
 
-	if req.DBName == "" || req.Table == "" || req.Where == "" {
-		http.Error(w, "All fields (dbname, table, where) are required", http.StatusBadRequest)
+	wc, err := effectiveConcern(task, requested)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid write concern", err)
 		return
 	}
 
-	query := fmt.Sprintf("DELETE FROM %s.%s WHERE %s", req.DBName, req.Table, req.Where)
-	_, err := db.Exec(query)
+	entry, err := commitWrite(task)
+	if err == errNotMaster || err == errNoQuorum {
+		writeNotMaster(w, err)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to delete record: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, "Failed to "+operationVerb(task.Operation), err)
 		return
 	}
 
-	replicationQueue <- ReplicationTask{
-		Operation: "delete",
-		Data: map[string]interface{}{
-			"dbname": req.DBName,
-			"table":  req.Table,
-			"where":  req.Where,
-		},
-	}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Record deleted successfully"})
-}
-
-func startElection() {
-	if electionInProgress {
+	// The commit LSN is the client's consistency token: passing it to a
+	// read makes the read wait for, or go to, a node that has the write.
+	w.Header().Set(tokenHeader, strconv.FormatUint(entry.LSN, 10))
+	acks, err := waitForConcern(entry, wc)
+	var cerr *errConcernNotMet
+	if errors.As(err, &cerr) {
+		// The write is committed and will still replicate; the client
+		// only learns that it is not yet as durable as requested.
+		writeJSON(w, http.StatusGatewayTimeout, map[string]interface{}{
+			"error":     err.Error(),
+			"code":      "write_concern_not_met",
+			"committed": true,
+			"lsn":       entry.LSN,
+			"acks":      acks,
+			"required":  cerr.Required,
+		})
 		return
 	}
-	electionInProgress = true
-
-	log.Println("Starting master election...")
-	time.Sleep(time.Second * 2)
-
-	if strings.HasSuffix(masterAddress, "8083") {
-		promoteToMaster()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to wait for the write concern", err)
+		return
 	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"message": message, "acks": acks, "lsn": entry.LSN})
 }
 
-func promoteToMaster() {
-	isMaster = true
-	masterAddress = "http://localhost:8083"
-	log.Println("This node has been promoted to master")
-}
-
-func checkMasterHealth() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		if !isMaster {
-			client := &http.Client{Timeout: 5 * time.Second}
-			_, err := client.Get(masterAddress + "/ping")
-			if err != nil {
-				log.Printf("Master is down: %v", err)
-				startElection()
-			}
-		}
+func operationVerb(operation string) string {
+	switch operation {
+	case "createdb":
+		return "create database"
+	case "dropdb":
+		return "drop database"
+	case "createtable":
+		return "create table"
+	case "insert":
+		return "insert record"
+	case "update":
+		return "update record"
+	case "delete":
+		return "delete record"
+	case "transaction":
+		return "commit transaction"
+	case "bulkinsert":
+		return "insert rows"
 	}
+	return operation
 }
 
-// Add periodic slave health check
-func startSlaveHealthCheck() {
-	ticker := time.NewTicker(5 * time.Second)
-	go func() {
-		for range ticker.C {
-			slaveConnections.Range(func(key, value interface{}) bool {
-				addr := key.(string)
-				status := value.(bool)
-				if status {
-					resp, err := http.Get(addr + "/ping")
-					if err != nil || resp.StatusCode != http.StatusOK {
-						slaveConnections.Store(addr, false)
-					} else {
-						resp.Body.Close()
-					}
-				}
-				return true
-			})
-		}
-	}()
-}
+var (
+	createDB     = writeHandler("createdb", "Database created successfully")
+	dropDB       = writeHandler("dropdb", "Database dropped successfully")
+	createTable  = writeHandler("createtable", "Table created successfully")
+	insertRecord = writeHandler("insert", "Record inserted successfully")
+	updateRecord = writeHandler("update", "Record updated successfully")
+	deleteRecord = writeHandler("delete", "Record deleted successfully")
+	transaction  = writeHandler("transaction", "Transaction committed successfully")
+)
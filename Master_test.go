@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMySQL is a database/sql driver whose statements are answered by
+// handle, so that code running against db can be tested without a server.
+// handle returns either a result or the rows of a query.
+type fakeMySQL struct {
+	mu     sync.Mutex
+	handle func(query string, args []interface{}) (driver.Result, *fakeRows, error)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (f *fakeMySQL) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeMySQL) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use sql.OpenDB") }
+
+type fakeConn struct{ f *fakeMySQL }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c fakeConn) run(query string, named []driver.NamedValue) (driver.Result, *fakeRows, error) {
+	args := make([]interface{}, len(named))
+	for i, v := range named {
+		args[i] = v.Value
+	}
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	return c.f.handle(query, args)
+}
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result, _, err := c.run(query, args)
+	if result == nil && err == nil {
+		result = driver.RowsAffected(0)
+	}
+	return result, err
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	_, rows, err := c.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &fakeRows{columns: []string{"value"}}
+	}
+	return &fakeCursor{rows: rows}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeCursor struct {
+	rows *fakeRows
+	next int
+}
+
+func (c *fakeCursor) Columns() []string { return c.rows.columns }
+func (c *fakeCursor) Close() error      { return nil }
+func (c *fakeCursor) Next(dest []driver.Value) error {
+	if c.next == len(c.rows.values) {
+		return io.EOF
+	}
+	copy(dest, c.rows.values[c.next])
+	c.next++
+	return nil
+}
+
+// withFakeDB points db at a fake server for the duration of a test.
+func withFakeDB(t *testing.T, handle func(query string, args []interface{}) (driver.Result, *fakeRows, error)) {
+	t.Helper()
+	saved := db
+	db = sql.OpenDB(&fakeMySQL{handle: handle})
+	t.Cleanup(func() {
+		db.Close()
+		db = saved
+	})
+}
+
+// withMaster makes this node the master of a one node cluster with an empty
+// log.
+func withMaster(t *testing.T) {
+	t.Helper()
+	savedCfg, savedTopology, savedLog := cfg, topology, replicationLog
+	savedElection, savedMaster, savedAddress := election, isMaster, masterAddress
+	t.Cleanup(func() {
+		cfg, topology, replicationLog = savedCfg, savedTopology, savedLog
+		election, isMaster, masterAddress = savedElection, savedMaster, savedAddress
+	})
+	cfg.Advertise = testSelf
+	cfg.DataDir = t.TempDir()
+	topology = Topology{Version: 1}
+	topology.add(testSelf, false)
+	replicationLog, _ = testLog(t, 0)
+	election = electionState{Term: 1, Master: testSelf}
+	isMaster = true
+	masterAddress = testSelf
+}
+
+// autoIncrementServer behaves like InnoDB for a table shop.orders with an
+// AUTO_INCREMENT id and a unique item column: every insert uses up ids, also
+// one that fails on a duplicate item, and a rollback gives none back.
+func autoIncrementServer() func(string, []interface{}) (driver.Result, *fakeRows, error) {
+	next := int64(0)
+	items := map[string]bool{}
+	return func(query string, args []interface{}) (driver.Result, *fakeRows, error) {
+		switch {
+		case strings.Contains(query, "information_schema.COLUMNS"):
+			return nil, &fakeRows{columns: []string{"COLUMN_NAME"}, values: [][]driver.Value{{"id"}}}, nil
+		case strings.Contains(query, "auto_increment_increment"):
+			return nil, &fakeRows{columns: []string{"step"}, values: [][]driver.Value{{int64(1)}}}, nil
+		case strings.HasPrefix(query, "INSERT INTO `shop`.`orders`"):
+			rows := int64(strings.Count(query[strings.Index(query, "VALUES"):], "("))
+			first := next + 1
+			next += rows
+			for _, arg := range args {
+				if item, ok := arg.(string); ok {
+					if items[item] {
+						return nil, nil, fmt.Errorf("Error 1062: Duplicate entry '%s' for key 'item'", item)
+					}
+					items[item] = true
+				}
+			}
+			return insertResult{first: first, rows: rows}, nil, nil
+		}
+		return nil, nil, nil
+	}
+}
+
+type insertResult struct {
+	first int64
+	rows  int64
+}
+
+func (r insertResult) LastInsertId() (int64, error) { return r.first, nil }
+func (r insertResult) RowsAffected() (int64, error) { return r.rows, nil }
+
+func TestCommitWriteLogsGeneratedIDs(t *testing.T) {
+	withMaster(t)
+	withFakeDB(t, autoIncrementServer())
+
+	insert := func(item string) ReplicationTask {
+		return ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"item": item}}
+	}
+	if _, err := commitWrite(insert("pen")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commitWrite(insert("pen")); err == nil {
+		t.Fatal("duplicate insert succeeded")
+	}
+	if _, err := commitWrite(insert("ink")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commitWrite(ReplicationTask{Operation: "bulkinsert", DBName: "shop", Table: "orders", Rows: []map[string]interface{}{
+		{"item": "a"}, {"item": "b"}, {"item": "c"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commitWrite(ReplicationTask{Operation: "transaction", DBName: "shop", Operations: []ReplicationTask{
+		insert("d"),
+		{Operation: "update", DBName: "shop", Table: "orders", Set: map[string]interface{}{"item": "e"}, Where: []Predicate{{Column: "id", Op: "=", Value: 1}}},
+		{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"id": 100, "item": "f"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed insert used up id 2, so ink is 3 on the master, and the
+	// log must say so: a slave would otherwise give it id 2.
+	entries, err := replicationLog.Entries(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, entry := range entries {
+		var ids []string
+		switch task := entry.Task; task.Operation {
+		case "insert":
+			ids = append(ids, fmt.Sprint(task.Values["id"]))
+		case "bulkinsert":
+			for _, row := range task.Rows {
+				ids = append(ids, fmt.Sprint(row["id"]))
+			}
+		case "transaction":
+			for _, op := range task.Operations {
+				ids = append(ids, fmt.Sprint(op.Values["id"]))
+			}
+		}
+		got = append(got, ids)
+	}
+	want := [][]string{{"1"}, {"3"}, {"4", "5", "6"}, {"7", "<nil>", "100"}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("logged ids = %v, want %v", got, want)
+	}
+
+	// A slave binds the id instead of generating one.
+	query, args, err := buildStatement(entries[1].Task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "INSERT INTO `shop`.`orders` (`id`, `item`) VALUES (?, ?)" || fmt.Sprint(args) != "[3 ink]" {
+		t.Errorf("slave statement = %q %v", query, args)
+	}
+}
+
+func TestNeedsID(t *testing.T) {
+	tests := []struct {
+		row  map[string]interface{}
+		want bool
+	}{
+		{map[string]interface{}{"item": "pen"}, true},
+		{map[string]interface{}{"id": nil}, true},
+		{map[string]interface{}{"id": json.Number("0")}, true},
+		{map[string]interface{}{"ID": float64(0)}, true},
+		{map[string]interface{}{"id": json.Number("7")}, false},
+		{map[string]interface{}{"Id": "7"}, false},
+	}
+	for _, tt := range tests {
+		if got := needsID(tt.row, "id"); got != tt.want {
+			t.Errorf("needsID(%v) = %v, want %v", tt.row, got, tt.want)
+		}
+	}
+	if row := withID(map[string]interface{}{"ID": nil, "item": "pen"}, "id", 5); len(row) != 2 || row["id"] != int64(5) {
+		t.Errorf("withID = %v", row)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Member is one node of the cluster. A non-voting member replicates and
+// serves reads but neither votes nor stands for election, and it does not
+// count toward the write quorum.
+type Member struct {
+	Address   string    `json:"address"`
+	Added     time.Time `json:"added"`
+	NonVoting bool      `json:"non_voting,omitempty"`
+}
+
+// Topology is the list of nodes that make up the cluster. The master owns it:
+// every change bumps Version, is persisted in topology.json and reaches the
+// other nodes with the master's heartbeats and registration replies. A node
+// adopts a topology only if its version is newer than the one it has.
+//
+// Removed lists nodes that were taken out of the cluster, so that a slave
+// which keeps running after its removal is not taken back in when it
+// registers again.
+type Topology struct {
+	Version uint64   `json:"version"`
+	Nodes   []Member `json:"nodes"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+var (
+	topoMu   sync.Mutex
+	topology Topology
+)
+
+func topologyPath() string {
+	return filepath.Join(cfg.DataDir, "topology.json")
+}
+
+// loadTopology restores the persisted topology. Without one the node starts
+// from the addresses it was configured with, at version 0.
+func loadTopology() error {
+	topoMu.Lock()
+	defer topoMu.Unlock()
+
+	data, err := os.ReadFile(topologyPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &topology); err != nil {
+			return fmt.Errorf("parsing %s: %w", topologyPath(), err)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	topology = Topology{}
+	seeds := append([]string{cfg.Advertise}, cfg.Peers...)
+	if cfg.Role == "slave" {
+		seeds = append(seeds, cfg.MasterAddress)
+	}
+	for _, addr := range seeds {
+		topology.add(addr, false)
+	}
+	return nil
+}
+
+func saveTopologyLocked() error {
+	data, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := topologyPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, topologyPath())
+}
+
+func (t *Topology) index(addr string) int {
+	for i, m := range t.Nodes {
+		if m.Address == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Topology) add(addr string, nonVoting bool) bool {
+	if addr == "" || t.index(addr) >= 0 {
+		return false
+	}
+	t.Nodes = append(t.Nodes, Member{Address: addr, Added: time.Now().UTC(), NonVoting: nonVoting})
+	sort.Slice(t.Nodes, func(i, j int) bool { return t.Nodes[i].Address < t.Nodes[j].Address })
+	return true
+}
+
+func (t *Topology) isVoter(addr string) bool {
+	i := t.index(addr)
+	return i >= 0 && !t.Nodes[i].NonVoting
+}
+
+func (t *Topology) wasRemoved(addr string) bool {
+	for _, removed := range t.Removed {
+		if removed == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// currentTopology returns a copy of this node's topology.
+func currentTopology() Topology {
+	topoMu.Lock()
+	defer topoMu.Unlock()
+	t := topology
+	t.Nodes = append([]Member(nil), topology.Nodes...)
+	t.Removed = append([]string(nil), topology.Removed...)
+	return t
+}
+
+// clusterNodes returns the addresses of every member, this node included.
+func clusterNodes() []string {
+	topoMu.Lock()
+	defer topoMu.Unlock()
+	nodes := make([]string, 0, len(topology.Nodes))
+	for _, m := range topology.Nodes {
+		nodes = append(nodes, m.Address)
+	}
+	return nodes
+}
+
+// otherMembers returns the addresses of every member but this node.
+func otherMembers() []string {
+	var others []string
+	for _, addr := range clusterNodes() {
+		if addr != cfg.Advertise {
+			others = append(others, addr)
+		}
+	}
+	return others
+}
+
+func isMember(addr string) bool {
+	topoMu.Lock()
+	defer topoMu.Unlock()
+	return topology.index(addr) >= 0
+}
+
+// isVoter reports whether addr is a voting member.
+func isVoter(addr string) bool {
+	topoMu.Lock()
+	defer topoMu.Unlock()
+	return topology.isVoter(addr)
+}
+
+// adoptTopology replaces this node's topology with t if t is newer.
+func adoptTopology(t *Topology) {
+	if t == nil {
+		return
+	}
+	topoMu.Lock()
+	defer topoMu.Unlock()
+	if t.Version <= topology.Version {
+		return
+	}
+	wasMember := topology.index(cfg.Advertise) >= 0
+	topology = *t
+	if err := saveTopologyLocked(); err != nil {
+		slog.Error("Failed to persist topology", "error", err)
+	}
+	switch isMemberNow := topology.index(cfg.Advertise) >= 0; {
+	case wasMember && !isMemberNow:
+		slog.Warn("This node has been removed from the cluster", "topology_version", topology.Version)
+	case !wasMember && isMemberNow:
+		slog.Info("This node has been added to the cluster", "topology_version", topology.Version)
+	}
+}
+
+// changeTopology applies change to the master's topology, bumps its version,
+// persists it and sends it to the other nodes right away.
+func changeTopology(change func(t *Topology) (bool, error)) (Topology, error) {
+	topoMu.Lock()
+	next := topology
+	next.Nodes = append([]Member(nil), topology.Nodes...)
+	next.Removed = append([]string(nil), topology.Removed...)
+	changed, err := change(&next)
+	if err != nil || !changed {
+		topoMu.Unlock()
+		return next, err
+	}
+	next.Version = topology.Version + 1
+	previous := topology
+	topology = next
+	if err := saveTopologyLocked(); err != nil {
+		topology = previous
+		topoMu.Unlock()
+		return previous, err
+	}
+	topoMu.Unlock()
+
+	slog.Info("Cluster topology changed", "topology_version", next.Version, "nodes", len(next.Nodes))
+	go heartbeatRound()
+	return next, nil
+}
+
+// addMember adds addr to the cluster. It also lifts an earlier removal.
+// Adding an existing non-voting member as a voter promotes it.
+func addMember(addr string, nonVoting bool) (Topology, error) {
+	return changeTopology(func(t *Topology) (bool, error) {
+		changed := t.add(addr, nonVoting)
+		if i := t.index(addr); i >= 0 && !nonVoting && t.Nodes[i].NonVoting {
+			t.Nodes[i].NonVoting = false
+			changed = true
+		}
+		for i, removed := range t.Removed {
+			if removed == addr {
+				t.Removed = append(t.Removed[:i], t.Removed[i+1:]...)
+				changed = true
+				break
+			}
+		}
+		return changed, nil
+	})
+}
+
+// removeMember takes addr out of the cluster and stops replicating to it.
+func removeMember(addr string) (Topology, error) {
+	if addr == cfg.Advertise {
+		return Topology{}, invalidf("the master cannot remove itself; switch over to another node first")
+	}
+	t, err := changeTopology(func(t *Topology) (bool, error) {
+		i := t.index(addr)
+		if i < 0 {
+			return false, nil
+		}
+		t.Nodes = append(t.Nodes[:i], t.Nodes[i+1:]...)
+		if !t.wasRemoved(addr) {
+			t.Removed = append(t.Removed, addr)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return t, err
+	}
+	if v, ok := slaveConnections.LoadAndDelete(addr); ok {
+		// The sender notices that it is no longer registered and exits.
+		v.(*slaveState).nudge()
+		notifyAck()
+	}
+	// Heartbeats only go to members, so tell the removed node once.
+	go announceLeader(addr, leaderAnnouncement{Term: currentTerm(), Master: cfg.Advertise, LSN: replicationLog.LastLSN(), Topology: &t})
+	return t, nil
+}
+
+// MemberStatus is a member as listed by /cluster/nodes. The master fills in
+// the replication state of each slave.
+type MemberStatus struct {
+	Member
+	Role     string        `json:"role"`
+	Online   *bool         `json:"online,omitempty"`
+	Health   *HealthStatus `json:"health,omitempty"`
+	AckedLSN uint64        `json:"acked_lsn,omitempty"`
+	LastErr  string        `json:"last_error,omitempty"`
+}
+
+func listMembers(w http.ResponseWriter, r *http.Request) {
+	t := currentTopology()
+	master := currentMaster()
+	members := make([]MemberStatus, 0, len(t.Nodes))
+	for _, m := range t.Nodes {
+		status := MemberStatus{Member: m, Role: "slave"}
+		if m.Address == master {
+			status.Role = "master"
+		} else if v, ok := slaveConnections.Load(m.Address); ok && isCurrentMaster() {
+			s := v.(*slaveState)
+			online := s.isOnline()
+			status.Online = &online
+			health := s.health.status()
+			status.Health = &health
+			status.AckedLSN = s.acked()
+			s.mu.Lock()
+			status.LastErr = s.lastErr
+			s.mu.Unlock()
+		}
+		members = append(members, status)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version": t.Version,
+		"master":  master,
+		"nodes":   members,
+		"removed": t.Removed,
+	})
+}
+
+func membershipHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		requireRole(roleReadOnly, listMembers)(w, r)
+	case http.MethodPost, http.MethodDelete:
+		requireRole(roleAdmin, masterOnly(changeMembers))(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Use GET, POST or DELETE", nil)
+	}
+}
+
+// changeMembers adds (POST) or removes (DELETE) the node named by the address
+// parameter or the address field of the body. Nodes added here vote.
+func changeMembers(w http.ResponseWriter, r *http.Request) {
+	addr := r.URL.Query().Get("address")
+	if addr == "" && r.Method == http.MethodPost {
+		var body struct {
+			Address string `json:"address"`
+		}
+		if err := decodeJSON(r.Body, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+		addr = body.Address
+	}
+	addr = strings.TrimSuffix(addr, "/")
+	if u, err := url.Parse(addr); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		writeError(w, http.StatusBadRequest, "address must be a node URL such as http://host:8084", nil)
+		return
+	}
+
+	var (
+		t   Topology
+		err error
+	)
+	if r.Method == http.MethodPost {
+		t, err = addMember(addr, false)
+	} else {
+		t, err = removeMember(addr)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to change cluster membership", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topology": t})
+}
+
+// leaveCluster is the decommissioning flow for a slave: it asks the master to
+// remove it, adopts the resulting topology and stops replicating and taking
+// part in elections. Its data stays in place.
+func leaveCluster(w http.ResponseWriter, r *http.Request) {
+	if isCurrentMaster() {
+		writeError(w, http.StatusConflict, "The master cannot leave; switch over to another node first", nil)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, currentMaster()+"/cluster/nodes?address="+url.QueryEscape(cfg.Advertise), nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to leave the cluster", err)
+		return
+	}
+	resp, err := nodeClient(0).Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "Failed to reach the master", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusBadGateway, "The master refused", responseError(resp))
+		return
+	}
+	var body struct {
+		Topology Topology `json:"topology"`
+	}
+	if err := decodeJSON(resp.Body, &body); err != nil {
+		writeError(w, http.StatusBadGateway, "Invalid reply from the master", err)
+		return
+	}
+	adoptTopology(&body.Topology)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Left the cluster; this node no longer replicates and can be shut down",
+		"lsn":     replicationLog.LastLSN(),
+	})
+}
+
+func defineMembershipRoutes() {
+	http.HandleFunc("/cluster/nodes", membershipHandler)
+	http.HandleFunc("/cluster/leave", requireRole(roleAdmin, leaveCluster))
+}
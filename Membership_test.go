@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testNewNode = "http://node-e:8087"
+
+// members lists the topology's nodes, marking the non-voting ones.
+func members(t Topology) []string {
+	var names []string
+	for _, m := range t.Nodes {
+		name := strings.TrimPrefix(m.Address, "http://")
+		if m.NonVoting {
+			name += " (non-voting)"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestAddMember(t *testing.T) {
+	tests := []struct {
+		name      string
+		removed   []string
+		addr      string
+		nonVoting bool
+		version   uint64
+		members   []string
+	}{
+		{"new voter", nil, testNewNode, false, 2, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)", "node-e:8087"}},
+		{"new non-voting member", nil, testNewNode, true, 2, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)", "node-e:8087 (non-voting)"}},
+		{"existing voter", nil, testOther, false, 1, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)"}},
+		{"voter added as non-voting", nil, testOther, true, 1, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)"}},
+		{"existing non-voting member", nil, testLearner, true, 1, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)"}},
+		{"promotion", nil, testLearner, false, 2, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086"}},
+		{"removed node comes back", []string{testNewNode}, testNewNode, false, 2, []string{"node-a:8083", "node-b:8084", "node-c:8085", "node-d:8086 (non-voting)", "node-e:8087"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withElection(t, electionState{Term: 1, Master: testSelf})
+			topology.Removed = tt.removed
+
+			got, err := addMember(tt.addr, tt.nonVoting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Version != tt.version || !reflect.DeepEqual(members(got), tt.members) {
+				t.Errorf("topology %d %v, want %d %v", got.Version, members(got), tt.version, tt.members)
+			}
+			if len(got.Removed) != 0 {
+				t.Errorf("removed = %v, want none", got.Removed)
+			}
+			if current := currentTopology(); current.Version != got.Version {
+				t.Errorf("current topology is version %d, want %d", current.Version, got.Version)
+			}
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	withElection(t, electionState{Term: 1, Master: testSelf})
+
+	if _, err := removeMember(testSelf); err == nil {
+		t.Error("the master removed itself")
+	}
+
+	got, err := removeMember(testOther)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"node-a:8083", "node-b:8084", "node-d:8086 (non-voting)"}
+	if got.Version != 2 || !reflect.DeepEqual(members(got), want) || !reflect.DeepEqual(got.Removed, []string{testOther}) {
+		t.Errorf("topology %d %v removed %v, want 2 %v removed [%s]", got.Version, members(got), got.Removed, want, testOther)
+	}
+
+	// Removing it again changes nothing.
+	if got, err := removeMember(testOther); err != nil || got.Version != 2 || len(got.Removed) != 1 {
+		t.Errorf("second removal: topology %d removed %v, %v", got.Version, got.Removed, err)
+	}
+
+	// The change survives a restart.
+	topology = Topology{}
+	if err := loadTopology(); err != nil {
+		t.Fatal(err)
+	}
+	if topology.Version != 2 || !reflect.DeepEqual(members(topology), want) {
+		t.Errorf("loaded topology %d %v, want 2 %v", topology.Version, members(topology), want)
+	}
+}
+
+func TestAdoptTopology(t *testing.T) {
+	withElection(t, electionState{Term: 1, Master: testOther})
+	topology.Version = 5
+	initial := members(topology)
+
+	newer := func(version uint64, addrs ...string) *Topology {
+		next := &Topology{Version: version}
+		for _, addr := range addrs {
+			next.add(addr, false)
+		}
+		return next
+	}
+
+	tests := []struct {
+		name    string
+		next    *Topology
+		version uint64
+		members []string
+	}{
+		{"nil", nil, 5, initial},
+		{"older", newer(4, testSelf, testOther), 5, initial},
+		{"same version", newer(5, testSelf, testOther), 5, initial},
+		{"newer", newer(6, testSelf, testOther), 6, []string{"node-a:8083", "node-c:8085"}},
+		{"removes this node", newer(8, testOther), 8, []string{"node-c:8085"}},
+		{"older again", newer(7, testSelf), 8, []string{"node-c:8085"}},
+	}
+	for _, tt := range tests {
+		adoptTopology(tt.next)
+		if current := currentTopology(); current.Version != tt.version || !reflect.DeepEqual(members(current), tt.members) {
+			t.Errorf("%s: topology %d %v, want %d %v", tt.name, current.Version, members(current), tt.version, tt.members)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The node keeps its own counters and renders them in the Prometheus text
+// format on /metrics. Gauges are read from the node's state at scrape time.
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency
+// histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	path   string
+	method string
+	code   int
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+var metrics = struct {
+	mu              sync.Mutex
+	requests        map[requestKey]uint64
+	latency         map[string]*histogram
+	mysqlErrors     map[string]uint64 // by operation
+	sendFailures    map[string]uint64 // by slave
+	elections       map[string]uint64 // by result
+	stepDowns       uint64
+	healthChanges   map[string]uint64 // by new state
+	concernTimeouts uint64
+}{
+	requests:      make(map[requestKey]uint64),
+	latency:       make(map[string]*histogram),
+	mysqlErrors:   make(map[string]uint64),
+	sendFailures:  make(map[string]uint64),
+	elections:     make(map[string]uint64),
+	healthChanges: make(map[string]uint64),
+}
+
+func countMySQLError(operation string) {
+	metrics.mu.Lock()
+	metrics.mysqlErrors[operation]++
+	metrics.mu.Unlock()
+}
+
+func countSendFailure(slave string) {
+	metrics.mu.Lock()
+	metrics.sendFailures[slave]++
+	metrics.mu.Unlock()
+}
+
+// countElection records the outcome of an election this node ran:
+// "prevote_failed", "lost" or "won".
+func countElection(result string) {
+	metrics.mu.Lock()
+	metrics.elections[result]++
+	metrics.mu.Unlock()
+}
+
+func countStepDown() {
+	metrics.mu.Lock()
+	metrics.stepDowns++
+	metrics.mu.Unlock()
+}
+
+func countHealthChange(state string) {
+	metrics.mu.Lock()
+	metrics.healthChanges[state]++
+	metrics.mu.Unlock()
+}
+
+func countConcernTimeout() {
+	metrics.mu.Lock()
+	metrics.concernTimeouts++
+	metrics.mu.Unlock()
+}
+
+// statusRecorder remembers the status code a handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts the requests mux serves and how long they take, labelled
+// by the route pattern that handled them so that query strings and unknown
+// paths cannot blow up the number of series.
+func instrument(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		started := time.Now()
+		mux.ServeHTTP(rec, r)
+		elapsed := time.Since(started).Seconds()
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		metrics.mu.Lock()
+		metrics.requests[requestKey{path: pattern, method: r.Method, code: rec.code}]++
+		h := metrics.latency[pattern]
+		if h == nil {
+			h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+			metrics.latency[pattern] = h
+		}
+		h.observe(elapsed)
+		metrics.mu.Unlock()
+	})
+}
+
+// promWriter writes metric families in the Prometheus text format.
+type promWriter struct {
+	w io.Writer
+}
+
+func (p promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(p.w, "%s%s %v\n", name, formatLabels(labels), value)
+}
+
+// formatLabels renders name/value pairs as {name="value",...}.
+func formatLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p := promWriter{w: w}
+	writeCounters(p)
+	writeGauges(p)
+}
+
+func writeCounters(p promWriter) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(metrics.requests))
+	for k := range metrics.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+	p.family("db_http_requests_total", "counter", "HTTP requests served, by route, method and status code.")
+	for _, k := range keys {
+		p.sample("db_http_requests_total", float64(metrics.requests[k]),
+			"path", k.path, "method", k.method, "code", fmt.Sprint(k.code))
+	}
+
+	paths := make([]string, 0, len(metrics.latency))
+	for path := range metrics.latency {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	p.family("db_http_request_duration_seconds", "histogram", "HTTP request latency, by route.")
+	for _, path := range paths {
+		h := metrics.latency[path]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			p.sample("db_http_request_duration_seconds_bucket", float64(cumulative), "path", path, "le", fmt.Sprint(bound))
+		}
+		p.sample("db_http_request_duration_seconds_bucket", float64(h.count), "path", path, "le", "+Inf")
+		p.sample("db_http_request_duration_seconds_sum", h.sum, "path", path)
+		p.sample("db_http_request_duration_seconds_count", float64(h.count), "path", path)
+	}
+
+	p.family("db_mysql_errors_total", "counter", "Statements that MySQL failed, by operation.")
+	for _, op := range sortedKeys(metrics.mysqlErrors) {
+		p.sample("db_mysql_errors_total", float64(metrics.mysqlErrors[op]), "operation", op)
+	}
+
+	p.family("db_replication_send_failures_total", "counter", "Failed attempts to send log entries to a slave.")
+	for _, slave := range sortedKeys(metrics.sendFailures) {
+		p.sample("db_replication_send_failures_total", float64(metrics.sendFailures[slave]), "slave", slave)
+	}
+
+	p.family("db_write_concern_timeouts_total", "counter", "Writes that committed but did not reach their write concern in time.")
+	p.sample("db_write_concern_timeouts_total", float64(metrics.concernTimeouts))
+
+	p.family("db_elections_total", "counter", "Elections this node ran, by result.")
+	for _, result := range sortedKeys(metrics.elections) {
+		p.sample("db_elections_total", float64(metrics.elections[result]), "result", result)
+	}
+
+	p.family("db_master_step_downs_total", "counter", "Times this node stepped down as master.")
+	p.sample("db_master_step_downs_total", float64(metrics.stepDowns))
+
+	p.family("db_health_changes_total", "counter", "Failure detector state changes, by new state.")
+	for _, state := range sortedKeys(metrics.healthChanges) {
+		p.sample("db_health_changes_total", float64(metrics.healthChanges[state]), "state", state)
+	}
+}
+
+func writeGauges(p promWriter) {
+	master := isCurrentMaster()
+	p.family("db_is_master", "gauge", "1 if this node is the master.")
+	p.sample("db_is_master", boolGauge(master))
+	p.family("db_term", "gauge", "Current election term.")
+	p.sample("db_term", float64(currentTerm()))
+	p.family("db_log_last_lsn", "gauge", "LSN of the newest entry in this node's replication log.")
+	p.sample("db_log_last_lsn", float64(replicationLog.LastLSN()))
+	p.family("db_topology_version", "gauge", "Version of the cluster topology this node knows.")
+	p.sample("db_topology_version", float64(currentTopology().Version))
+
+	if !master {
+		p.family("db_staleness_seconds", "gauge", "How far this slave may be behind the master; absent when unknown.")
+		if lag, known := staleness(); known {
+			p.sample("db_staleness_seconds", lag.Seconds())
+		}
+		return
+	}
+
+	// The master replicates through one sender per slave; the entries a
+	// slave has not acknowledged yet are its queue.
+	status := replicationStatus()
+	p.family("db_replication_queue_depth", "gauge", "Log entries not yet acknowledged by a slave.")
+	for _, s := range status.Slaves {
+		p.sample("db_replication_queue_depth", float64(s.LagEntries), "slave", s.Address)
+	}
+	p.family("db_replication_lag_seconds", "gauge", "Age of the oldest entry a slave has not applied.")
+	for _, s := range status.Slaves {
+		if s.LagSeconds >= 0 {
+			p.sample("db_replication_lag_seconds", s.LagSeconds, "slave", s.Address)
+		}
+	}
+	p.family("db_slave_online", "gauge", "1 if a slave is online.")
+	for _, s := range status.Slaves {
+		p.sample("db_slave_online", boolGauge(s.Online), "slave", s.Address)
+	}
+	p.family("db_slave_health", "gauge", "1 for the failure detector state a slave is in.")
+	for _, s := range status.Slaves {
+		for _, state := range []string{healthAlive, healthSuspect, healthDead, healthRecovering} {
+			p.sample("db_slave_health", boolGauge(s.Health == state), "slave", s.Address, "state", state)
+		}
+	}
+}
+
+func defineMetricsRoutes() {
+	http.HandleFunc("/metrics", requireRole(roleReadOnly, serveMetrics))
+}
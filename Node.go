@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// Config describes a single node of the cluster. It is read from an optional
+// JSON file and can be overridden by command line flags.
+type Config struct {
+	Role          string   `json:"role"`           // "master" or "slave"
+	Listen        string   `json:"listen"`         // address the HTTP server binds to, e.g. ":8084"
+	Advertise     string   `json:"advertise"`      // URL other nodes use to reach this node
+	DSN           string   `json:"dsn"`            // MySQL data source name
+	MasterAddress string   `json:"master_address"` // URL of the master node
+	Peers         []string `json:"peers"`          // URLs of the other nodes the cluster starts with
+	DataDir       string   `json:"data_dir"`       // directory for the replication log and node state
+	Dashboard     bool     `json:"dashboard"`      // show the interactive terminal dashboard
+
+	// SnapshotThreshold is how many entries a slave may lag behind before it
+	// reloads a snapshot instead of replaying the log. 0 disables the check.
+	SnapshotThreshold uint64 `json:"snapshot_threshold"`
+
+	// WriteConcern is the cluster default for writes that do not ask for
+	// one. TableWriteConcerns sets a minimum per "db.table" or per "db".
+	WriteConcern       WriteConcern            `json:"write_concern"`
+	TableWriteConcerns map[string]WriteConcern `json:"table_write_concerns"`
+
+	// ElectionTimeoutMS is how long a slave waits without hearing from the
+	// master before it tries to become master itself. The actual wait is
+	// randomized between one and two timeouts.
+	ElectionTimeoutMS int `json:"election_timeout_ms"`
+
+	// StaleReads says what a slave does with a read it is too far behind
+	// for: "forward" it to the master (the default) or "refuse" it.
+	StaleReads string `json:"stale_reads"`
+
+	// ReadWaitMS is how long a read with a consistency token waits for this
+	// node to apply the token's LSN before it is redirected to the master.
+	ReadWaitMS int `json:"read_wait_ms"`
+
+	// FailureDetector sets the heartbeats the master uses to decide whether
+	// a slave is alive, suspect, dead or recovering.
+	FailureDetector FailureDetectorConfig `json:"failure_detector"`
+
+	// ReplicationFilter limits which databases and tables a slave receives.
+	// Only slaves that replicate everything can become master.
+	ReplicationFilter ReplicationFilter `json:"replication_filter"`
+
+	// CORS is the policy for browser clients.
+	CORS CORSConfig `json:"cors"`
+
+	// TLS holds the certificate paths for HTTPS and mutual TLS.
+	TLS TLSConfig `json:"tls"`
+
+	// Auth holds the node token and the client API keys.
+	Auth AuthConfig `json:"auth"`
+
+	// LogLevel is debug, info, warn or error; LogFormat is json or text.
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+}
+
+var (
+	db  *sql.DB
+	cfg Config
+
+	// nodeMu guards the node's role. Use isCurrentMaster and currentMaster
+	// to read it; elections change it at runtime.
+	nodeMu             sync.Mutex
+	isMaster           bool
+	masterAddress      string
+	electionInProgress bool
+)
+
+func defaultConfig() Config {
+	return Config{
+		Role:          "master",
+		DSN:           "root:k7l15981@tcp(127.0.0.1:3306)/",
+		MasterAddress: "http://localhost:8083",
+		Dashboard:     true,
+
+		SnapshotThreshold: 100000,
+		StaleReads:        "forward",
+		LogLevel:          "info",
+		LogFormat:         "json",
+		CORS:              defaultCORS(),
+		FailureDetector: FailureDetectorConfig{
+			IntervalMS:   1000,
+			SuspectAfter: 2,
+			DeadAfter:    5,
+			RecoverAfter: 3,
+		},
+	}
+}
+
+// loadConfig builds the node configuration from defaults, the optional config
+// file and finally any flags that were set explicitly on the command line.
+func loadConfig(args []string) (Config, error) {
+	c := defaultConfig()
+
+	fs := flag.NewFlagSet("node", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a JSON config file")
+	role := fs.String("role", c.Role, "node role: master or slave")
+	listen := fs.String("listen", "", "listen address (default :8083 for master, :8084 for slave)")
+	advertise := fs.String("advertise", "", "URL other nodes use to reach this node")
+	dsn := fs.String("dsn", c.DSN, "MySQL data source name")
+	master := fs.String("master", c.MasterAddress, "URL of the master node")
+	peers := fs.String("peers", "", "comma separated URLs of the other nodes")
+	dataDir := fs.String("data-dir", "", "directory for node state (default data/<port>)")
+	writeConcern := fs.String("write-concern", "", "default write concern: async, semi-sync or sync")
+	dashboard := fs.Bool("dashboard", c.Dashboard, "show the interactive dashboard")
+	logLevel := fs.String("log-level", c.LogLevel, "log level: debug, info, warn or error")
+	tlsCert := fs.String("tls-cert", "", "certificate file; enables HTTPS")
+	tlsKey := fs.String("tls-key", "", "private key file of the certificate")
+	tlsCA := fs.String("tls-ca", "", "CA file that signs the node certificates; enables mutual TLS")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return c, fmt.Errorf("reading config: %w", err)
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("parsing config: %w", err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "role":
+			c.Role = *role
+		case "listen":
+			c.Listen = *listen
+		case "advertise":
+			c.Advertise = *advertise
+		case "dsn":
+			c.DSN = *dsn
+		case "master":
+			c.MasterAddress = *master
+		case "peers":
+			c.Peers = splitList(*peers)
+		case "data-dir":
+			c.DataDir = *dataDir
+		case "write-concern":
+			c.WriteConcern.Level = *writeConcern
+		case "dashboard":
+			c.Dashboard = *dashboard
+		case "log-level":
+			c.LogLevel = *logLevel
+		case "tls-cert":
+			c.TLS.CertFile = *tlsCert
+		case "tls-key":
+			c.TLS.KeyFile = *tlsKey
+		case "tls-ca":
+			c.TLS.CAFile = *tlsCA
+		}
+	})
+
+	c.Role = strings.ToLower(c.Role)
+	if c.Role != "master" && c.Role != "slave" {
+		return c, fmt.Errorf("invalid role %q: must be master or slave", c.Role)
+	}
+	if _, err := c.WriteConcern.normalize(); err != nil {
+		return c, fmt.Errorf("write_concern: %w", err)
+	}
+	if c.StaleReads != "forward" && c.StaleReads != "refuse" {
+		return c, fmt.Errorf("invalid stale_reads %q: must be forward or refuse", c.StaleReads)
+	}
+	if err := c.FailureDetector.validate(); err != nil {
+		return c, fmt.Errorf("failure_detector: %w", err)
+	}
+	if err := c.CORS.validate(); err != nil {
+		return c, fmt.Errorf("cors: %w", err)
+	}
+	if err := c.TLS.validate(); err != nil {
+		return c, fmt.Errorf("tls: %w", err)
+	}
+	if err := c.Auth.validate(); err != nil {
+		return c, fmt.Errorf("auth: %w", err)
+	}
+	if err := c.ReplicationFilter.validate(); err != nil {
+		return c, fmt.Errorf("replication_filter: %w", err)
+	}
+	if c.Role == "master" && c.ReplicationFilter.active() {
+		return c, fmt.Errorf("replication_filter: only slaves can filter what they replicate")
+	}
+	for key, wc := range c.TableWriteConcerns {
+		if _, err := wc.normalize(); err != nil {
+			return c, fmt.Errorf("table_write_concerns[%s]: %w", key, err)
+		}
+	}
+	if c.Listen == "" {
+		if c.Role == "master" {
+			c.Listen = ":8083"
+		} else {
+			c.Listen = ":8084"
+		}
+	}
+	if c.Advertise == "" {
+		scheme := "http://"
+		if c.TLS.enabled() {
+			scheme = "https://"
+		}
+		if strings.HasPrefix(c.Listen, ":") {
+			c.Advertise = scheme + "localhost" + c.Listen
+		} else {
+			c.Advertise = scheme + c.Listen
+		}
+	}
+	if c.DataDir == "" {
+		port := c.Listen
+		if i := strings.LastIndex(port, ":"); i >= 0 {
+			port = port[i+1:]
+		}
+		c.DataDir = filepath.Join("data", port)
+	}
+	if c.Role == "master" {
+		c.MasterAddress = c.Advertise
+	}
+	c.MasterAddress = strings.TrimSuffix(c.MasterAddress, "/")
+	c.Advertise = strings.TrimSuffix(c.Advertise, "/")
+	return c, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			out = append(out, strings.TrimSuffix(part, "/"))
+		}
+	}
+	return out
+}
+
+func main() {
+	var err error
+	cfg, err = loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	isMaster = cfg.Role == "master"
+	masterAddress = cfg.MasterAddress
+
+	db, err = sql.Open("mysql", cfg.DSN)
+	if err != nil {
+		fatal("Invalid database DSN", err)
+	}
+	defer db.Close()
+
+	err = db.Ping()
+	if err != nil {
+		fatal("Failed to connect to database", err)
+	}
+
+	startNode()
+
+	if !cfg.Dashboard {
+		select {}
+	}
+	runDashboard()
+}
+
+// startNode opens the node's replication log and election state, serves the
+// HTTP API and starts the background work of both roles. Every node registers
+// the master routes, which only answer while the node is master, so a slave
+// can take over without a restart.
+func startNode() {
+	var err error
+	replicationLog, err = openReplicationLog(cfg.DataDir)
+	if err != nil {
+		fatal("Failed to open replication log", err)
+	}
+	if err := recoverAppliedState(); err != nil {
+		fatal("Failed to recover the replication state in MySQL", err)
+	}
+	if err := loadElectionState(); err != nil {
+		fatal("Failed to load election state", err)
+	}
+	if err := loadTLS(); err != nil {
+		fatal("Failed to load TLS certificates", err)
+	}
+	if err := loadTopology(); err != nil {
+		fatal("Failed to load cluster topology", err)
+	}
+
+	defineBasicRoutes()
+	defineMasterRoutes()
+	defineElectionRoutes()
+	defineReadRoutes()
+	defineMembershipRoutes()
+	defineMetricsRoutes()
+	go func() {
+		slog.Info("Node running", "listen", cfg.Listen, "role", cfg.Role)
+		fatal("HTTP server stopped", listenAndServe(withRequestID(withCORS(http.DefaultServeMux, withAuth(instrument(http.DefaultServeMux))))))
+	}()
+
+	startFailureDetector()
+	go followMaster()
+	go checkMasterHealth()
+	go sendHeartbeats()
+}
+
+// NodeStatus is a node's view of the cluster, served on /status. Clients use
+// it to find the master and the slaves they can read from.
+type NodeStatus struct {
+	Address string   `json:"address"`
+	Role    string   `json:"role"`
+	Master  string   `json:"master"`
+	Term    uint64   `json:"term"`
+	LSN     uint64   `json:"lsn"`
+	Peers   []string `json:"peers"`
+	// TopologyVersion is the version of the cluster topology Peers comes
+	// from.
+	TopologyVersion uint64 `json:"topology_version"`
+	// MasterHealth is a slave's failure detector view of its master.
+	MasterHealth *HealthStatus `json:"master_health,omitempty"`
+	// StalenessMS is how far a slave may be behind the master, or -1 when
+	// it does not know.
+	StalenessMS int64 `json:"staleness_ms"`
+	// Filter is the replication filter of a slave that only holds some
+	// databases or tables.
+	Filter *ReplicationFilter `json:"filter,omitempty"`
+}
+
+func nodeStatus() NodeStatus {
+	status := NodeStatus{
+		Address: cfg.Advertise,
+		Role:    "slave",
+		Master:  currentMaster(),
+		Term:    currentTerm(),
+		LSN:     replicationLog.LastLSN(),
+		Peers:   otherMembers(),
+	}
+	status.TopologyVersion = currentTopology().Version
+	if isCurrentMaster() {
+		status.Role = "master"
+	} else {
+		health := masterHealth.status()
+		status.MasterHealth = &health
+		if cfg.ReplicationFilter.active() {
+			filter := cfg.ReplicationFilter
+			status.Filter = &filter
+		}
+	}
+	status.StalenessMS = -1
+	if lag, known := staleness(); known {
+		status.StalenessMS = lag.Milliseconds()
+	}
+	return status
+}
+
+// runDashboard shows the dashboard of the node's current role and switches
+// to the other one when the role changes, for example after an election.
+func runDashboard() {
+	for {
+		var exit bool
+		if isCurrentMaster() {
+			exit = runMasterDashboard()
+		} else {
+			exit = runSlaveDashboard()
+		}
+		if exit {
+			return
+		}
+	}
+}
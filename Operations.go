@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ReplicationTask is a structured write operation. It is what the HTTP API
+// accepts, what the master stores in the replication log and what slaves
+// apply. Identifiers are validated and values are always bound as statement
+// parameters, so no SQL text ever travels between nodes.
+type ReplicationTask struct {
+	Operation string                 `json:"operation"`
+	DBName    string                 `json:"dbname"`
+	Table     string                 `json:"table,omitempty"`
+	Columns   []ColumnDef            `json:"columns,omitempty"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+	Set       map[string]interface{} `json:"set,omitempty"`
+	Where     []Predicate            `json:"where,omitempty"`
+
+	// Rows are whole rows, keyed by column name. A bulk insert inserts them;
+	// a repair replaces the rows matching Where with them.
+	Rows []map[string]interface{} `json:"rows,omitempty"`
+
+	// Operations are the inserts, updates and deletes of a transaction,
+	// which is applied and replicated as a whole.
+	Operations []ReplicationTask `json:"operations,omitempty"`
+
+	// RequestID is the ID of the API request that made the write. Slaves
+	// log it when they apply the entry.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ColumnDef describes one column of a table.
+type ColumnDef struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	NotNull       bool   `json:"not_null,omitempty"`
+	PrimaryKey    bool   `json:"primary_key,omitempty"`
+	AutoIncrement bool   `json:"auto_increment,omitempty"`
+	Unique        bool   `json:"unique,omitempty"`
+}
+
+// Predicate is one condition of a WHERE clause. A leaf compares Column with
+// Value (or Values for "in" and "not in"); a group combines its And or Or
+// predicates. A list of predicates is combined with AND.
+type Predicate struct {
+	Column string        `json:"column,omitempty"`
+	Op     string        `json:"op,omitempty"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+	And    []Predicate   `json:"and,omitempty"`
+	Or     []Predicate   `json:"or,omitempty"`
+}
+
+// validationError reports a malformed operation. The HTTP API answers it with
+// 400 Bad Request instead of 500.
+type validationError struct {
+	msg string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return &validationError{msg: fmt.Sprintf(format, args...)}
+}
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,63}$`)
+
+// validIdent reports whether name can be used as a database, table or column
+// name.
+func validIdent(name string) bool {
+	return identPattern.MatchString(name)
+}
+
+func checkIdent(kind, name string) error {
+	if name == "" {
+		return invalidf("%s name is required", kind)
+	}
+	if !validIdent(name) {
+		return invalidf("invalid %s name %q", kind, name)
+	}
+	if kind == "database" && strings.EqualFold(name, stateDatabase) {
+		return invalidf("database name %q is reserved", name)
+	}
+	return nil
+}
+
+// columnTypePatterns lists the column types a table may use.
+var columnTypePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^(TINYINT|SMALLINT|MEDIUMINT|INT|INTEGER|BIGINT)(\(\d{1,3}\))?( UNSIGNED)?( ZEROFILL)?$`),
+	regexp.MustCompile(`(?i)^(DECIMAL|NUMERIC|FLOAT|DOUBLE)(\(\d{1,2}(,\d{1,2})?\))?( UNSIGNED)?( ZEROFILL)?$`),
+	regexp.MustCompile(`(?i)^(CHAR|VARCHAR|BINARY|VARBINARY)\(\d{1,5}\)$`),
+	regexp.MustCompile(`(?i)^(BIT)(\(\d{1,2}\))?$`),
+	regexp.MustCompile(`(?i)^(DATETIME|TIMESTAMP|TIME)(\(\d\))?$`),
+	regexp.MustCompile(`(?i)^(DATE|YEAR|JSON|BOOL|BOOLEAN|TINYTEXT|TEXT|MEDIUMTEXT|LONGTEXT|TINYBLOB|BLOB|MEDIUMBLOB|LONGBLOB)$`),
+	regexp.MustCompile(`(?i)^YEAR\(4\)$`), // MySQL 5.7 reports YEAR as year(4)
+	regexp.MustCompile(`(?i)^(GEOMETRY|POINT|LINESTRING|POLYGON|MULTIPOINT|MULTILINESTRING|MULTIPOLYGON|GEOMETRYCOLLECTION|GEOMCOLLECTION)$`),
+	regexp.MustCompile(`(?i)^(ENUM|SET)\('([^'\\]|'')*'(,\s*'([^'\\]|'')*')*\)$`),
+}
+
+// normalizeColumnType collapses whitespace in a column type and checks it
+// against the allowed types.
+func normalizeColumnType(t string) (string, error) {
+	t = strings.TrimSpace(t)
+	if !strings.Contains(t, "'") {
+		// Leave ENUM and SET members exactly as they were given.
+		t = strings.Join(strings.Fields(t), " ")
+		t = strings.ReplaceAll(t, ", ", ",")
+	}
+	for _, pattern := range columnTypePatterns {
+		if pattern.MatchString(t) {
+			return t, nil
+		}
+	}
+	return "", invalidf("unsupported column type %q", t)
+}
+
+// checkValue makes sure a value can be bound as a statement parameter.
+func checkValue(column string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, string, bool, float64, int, int64, []byte:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case binaryValue:
+		return v.Bytes, nil
+	case map[string]interface{}:
+		if s, ok := v[binaryKey].(string); ok && len(v) == 1 {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, invalidf("value for column %q is not valid base64: %v", column, err)
+			}
+			return b, nil
+		}
+	}
+	return nil, invalidf(`value for column %q must be a string, number, boolean, null or {"$binary": "<base64>"}`, column)
+}
+
+// assignments renders a column/value map as sorted, quoted column names and
+// the matching parameters.
+func assignments(values map[string]interface{}) ([]string, []interface{}, error) {
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	quoted := make([]string, len(cols))
+	args := make([]interface{}, len(cols))
+	for i, col := range cols {
+		if err := checkIdent("column", col); err != nil {
+			return nil, nil, err
+		}
+		v, err := checkValue(col, values[col])
+		if err != nil {
+			return nil, nil, err
+		}
+		quoted[i] = quoteIdent(col)
+		args[i] = v
+	}
+	return quoted, args, nil
+}
+
+// buildWhere renders predicates combined with AND.
+func buildWhere(preds []Predicate) (string, []interface{}, error) {
+	return buildGroup(preds, " AND ")
+}
+
+func buildGroup(preds []Predicate, sep string) (string, []interface{}, error) {
+	if len(preds) == 0 {
+		return "", nil, invalidf("empty predicate group")
+	}
+	parts := make([]string, len(preds))
+	var args []interface{}
+	for i, p := range preds {
+		clause, pargs, err := buildPredicate(p)
+		if err != nil {
+			return "", nil, err
+		}
+		parts[i] = clause
+		args = append(args, pargs...)
+	}
+	if len(parts) == 1 {
+		return parts[0], args, nil
+	}
+	return "(" + strings.Join(parts, sep) + ")", args, nil
+}
+
+func buildPredicate(p Predicate) (string, []interface{}, error) {
+	switch {
+	case len(p.And) > 0:
+		return buildGroup(p.And, " AND ")
+	case len(p.Or) > 0:
+		return buildGroup(p.Or, " OR ")
+	}
+
+	if err := checkIdent("column", p.Column); err != nil {
+		return "", nil, err
+	}
+	col := quoteIdent(p.Column)
+
+	op := strings.ToLower(strings.Join(strings.Fields(p.Op), " "))
+	switch op {
+	case "=", "!=", "<>", "<", "<=", ">", ">=", "like", "not like":
+		if p.Value == nil {
+			return "", nil, invalidf("operator %q on column %q needs a value, use \"is null\" to match NULL", p.Op, p.Column)
+		}
+		v, err := checkValue(p.Column, p.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("%s %s ?", col, strings.ToUpper(op)), []interface{}{v}, nil
+	case "in", "not in":
+		if len(p.Values) == 0 {
+			return "", nil, invalidf("operator %q on column %q needs a non-empty values list", p.Op, p.Column)
+		}
+		args := make([]interface{}, len(p.Values))
+		for i, raw := range p.Values {
+			v, err := checkValue(p.Column, raw)
+			if err != nil {
+				return "", nil, err
+			}
+			args[i] = v
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+		return fmt.Sprintf("%s %s (%s)", col, strings.ToUpper(op), placeholders), args, nil
+	case "is null", "is not null":
+		return fmt.Sprintf("%s %s", col, strings.ToUpper(op)), nil, nil
+	default:
+		return "", nil, invalidf("unsupported operator %q", p.Op)
+	}
+}
+
+// buildColumnDefs renders the column list of a CREATE TABLE statement.
+func buildColumnDefs(columns []ColumnDef) (string, error) {
+	if len(columns) == 0 {
+		return "", invalidf("at least one column is required")
+	}
+
+	var defs, primary []string
+	for _, c := range columns {
+		if err := checkIdent("column", c.Name); err != nil {
+			return "", err
+		}
+		colType, err := normalizeColumnType(c.Type)
+		if err != nil {
+			return "", err
+		}
+
+		def := quoteIdent(c.Name) + " " + colType
+		if c.NotNull || c.PrimaryKey {
+			def += " NOT NULL"
+		}
+		if c.AutoIncrement {
+			def += " AUTO_INCREMENT"
+		}
+		if c.Unique {
+			def += " UNIQUE"
+		}
+		defs = append(defs, def)
+		if c.PrimaryKey {
+			primary = append(primary, quoteIdent(c.Name))
+		}
+	}
+	if len(primary) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(primary, ", ")+")")
+	}
+	return strings.Join(defs, ", "), nil
+}
+
+// buildStatement validates a task and turns it into a parameterized
+// statement.
+func buildStatement(task ReplicationTask) (string, []interface{}, error) {
+	if err := checkIdent("database", task.DBName); err != nil {
+		return "", nil, err
+	}
+	dbName := quoteIdent(task.DBName)
+
+	switch task.Operation {
+	case "createdb":
+		return "CREATE DATABASE IF NOT EXISTS " + dbName, nil, nil
+	case "dropdb":
+		return "DROP DATABASE IF EXISTS " + dbName, nil, nil
+	}
+
+	if err := checkIdent("table", task.Table); err != nil {
+		return "", nil, err
+	}
+	table := dbName + "." + quoteIdent(task.Table)
+
+	switch task.Operation {
+	case "createtable":
+		defs, err := buildColumnDefs(task.Columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, defs), nil, nil
+	case "insert":
+		if len(task.Values) == 0 {
+			return "", nil, invalidf("values are required")
+		}
+		cols, args, err := assignments(task.Values)
+		if err != nil {
+			return "", nil, err
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), placeholders), args, nil
+	case "update":
+		if len(task.Set) == 0 {
+			return "", nil, invalidf("set is required")
+		}
+		if len(task.Where) == 0 {
+			return "", nil, invalidf("where is required")
+		}
+		cols, args, err := assignments(task.Set)
+		if err != nil {
+			return "", nil, err
+		}
+		for i := range cols {
+			cols[i] += " = ?"
+		}
+		where, whereArgs, err := buildWhere(task.Where)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(cols, ", "), where), append(args, whereArgs...), nil
+	case "delete":
+		if len(task.Where) == 0 {
+			return "", nil, invalidf("where is required")
+		}
+		where, args, err := buildWhere(task.Where)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("DELETE FROM %s WHERE %s", table, where), args, nil
+	default:
+		return "", nil, invalidf("unknown operation %q", task.Operation)
+	}
+}
+
+// execer is satisfied by *sql.DB, *sql.Tx and *sql.Conn.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// applyTask executes a structured operation against the local database.
+func applyTask(ex execer, task ReplicationTask) (sql.Result, error) {
+	var build func(ReplicationTask) ([]statement, error)
+	switch task.Operation {
+	case "repair":
+		build = buildRepair
+	case "transaction":
+		build = buildTransaction
+	case "bulkinsert":
+		build = buildBulkInsert
+	}
+	if build != nil {
+		stmts, err := build(task)
+		if err != nil {
+			return nil, err
+		}
+		return applyStatements(ex, task.Operation, stmts)
+	}
+
+	query, args, err := buildStatement(task)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ex.ExecContext(context.Background(), query, args...)
+	if err != nil {
+		countMySQLError(task.Operation)
+	}
+	return result, err
+}
+
+// execQuerier is satisfied by *sql.Tx and *sql.Conn.
+type execQuerier interface {
+	execer
+	querier
+}
+
+// applyAssigningIDs applies task on the master and returns it with the
+// values MySQL generated for AUTO_INCREMENT columns filled in. The log holds
+// the rows as MySQL created them, so slaves insert the same ids instead of
+// generating their own: InnoDB loses ids to failed inserts and rolled back
+// transactions on the master, and slaves never see those gaps.
+func applyAssigningIDs(ex execQuerier, task ReplicationTask) (ReplicationTask, error) {
+	switch task.Operation {
+	case "insert":
+		return insertAssigningID(ex, task)
+	case "bulkinsert":
+		return bulkInsertAssigningIDs(ex, task)
+	case "transaction":
+		if _, err := buildTransaction(task); err != nil {
+			return task, err
+		}
+		ops := make([]ReplicationTask, len(task.Operations))
+		for i, op := range task.Operations {
+			var err error
+			if op.Operation == "insert" {
+				op, err = insertAssigningID(ex, op)
+			} else {
+				_, err = applyTask(ex, op)
+			}
+			if err != nil {
+				return task, err
+			}
+			ops[i] = op
+		}
+		task.Operations = ops
+		return task, nil
+	}
+	_, err := applyTask(ex, task)
+	return task, err
+}
+
+func insertAssigningID(ex execQuerier, task ReplicationTask) (ReplicationTask, error) {
+	result, err := applyTask(ex, task)
+	if err != nil {
+		return task, err
+	}
+	col, err := autoIncrementColumn(ex, task.DBName, task.Table)
+	if err != nil || col == "" || !needsID(task.Values, col) {
+		return task, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return task, err
+	}
+	task.Values = withID(task.Values, col, id)
+	return task, nil
+}
+
+// bulkInsertAssigningIDs inserts the rows batch by batch. MySQL gives the
+// rows of one multi-row INSERT consecutive ids, auto_increment_increment
+// apart, starting at the statement's LastInsertId.
+func bulkInsertAssigningIDs(ex execQuerier, task ReplicationTask) (ReplicationTask, error) {
+	stmts, err := buildBulkInsert(task)
+	if err != nil {
+		return task, err
+	}
+	col, err := autoIncrementColumn(ex, task.DBName, task.Table)
+	if err != nil {
+		return task, err
+	}
+	missing := 0
+	for _, row := range task.Rows {
+		if col != "" && needsID(row, col) {
+			missing++
+		}
+	}
+	if missing == 0 {
+		_, err := applyStatements(ex, task.Operation, stmts)
+		return task, err
+	}
+	if missing != len(task.Rows) {
+		return task, invalidf("either every row or no row must set column %q", col)
+	}
+
+	var step int64
+	rows, err := ex.QueryContext(context.Background(), "SELECT @@session.auto_increment_increment")
+	if err != nil {
+		return task, err
+	}
+	for rows.Next() {
+		err = rows.Scan(&step)
+	}
+	rows.Close()
+	if err != nil {
+		return task, err
+	}
+	if step < 1 {
+		step = 1
+	}
+
+	withIDs := make([]map[string]interface{}, len(task.Rows))
+	for b, stmt := range stmts {
+		result, err := ex.ExecContext(context.Background(), stmt.query, stmt.args...)
+		if err != nil {
+			countMySQLError(task.Operation)
+			return task, err
+		}
+		first, err := result.LastInsertId()
+		if err != nil {
+			return task, err
+		}
+		start := b * insertBatchSize
+		for i := start; i < len(task.Rows) && i < start+insertBatchSize; i++ {
+			withIDs[i] = withID(task.Rows[i], col, first+int64(i-start)*step)
+		}
+	}
+	task.Rows = withIDs
+	return task, nil
+}
+
+// autoIncrementColumn returns the AUTO_INCREMENT column of a table, or "".
+func autoIncrementColumn(q querier, dbname, table string) (string, error) {
+	rows, err := q.QueryContext(context.Background(), `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND EXTRA LIKE '%auto_increment%'`, dbname, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var col string
+	for rows.Next() {
+		if err := rows.Scan(&col); err != nil {
+			return "", err
+		}
+	}
+	return col, rows.Err()
+}
+
+// needsID reports whether MySQL generates the value of col for row: the
+// row leaves it out, or sets it to NULL or 0.
+func needsID(row map[string]interface{}, col string) bool {
+	for name, v := range row {
+		if strings.EqualFold(name, col) {
+			return v == nil || fmt.Sprint(v) == "0"
+		}
+	}
+	return true
+}
+
+// withID returns a copy of row with col set to id.
+func withID(row map[string]interface{}, col string, id int64) map[string]interface{} {
+	out := make(map[string]interface{}, len(row)+1)
+	for name, v := range row {
+		if !strings.EqualFold(name, col) {
+			out[name] = v
+		}
+	}
+	out[col] = id
+	return out
+}
+
+// statement is one parameterized SQL statement.
+type statement struct {
+	query string
+	args  []interface{}
+}
+
+// applyStatements executes the statements of one operation. Given the
+// database itself it runs them in a transaction, so that the operation is
+// applied completely or not at all; given a transaction or connection it
+// leaves that to the caller.
+func applyStatements(ex execer, operation string, stmts []statement) (sql.Result, error) {
+	ctx := context.Background()
+	var tx *sql.Tx
+	if d, ok := ex.(*sql.DB); ok {
+		var err error
+		if tx, err = d.BeginTx(ctx, nil); err != nil {
+			countMySQLError(operation)
+			return nil, err
+		}
+		defer tx.Rollback()
+		ex = tx
+	}
+
+	var last sql.Result
+	for _, stmt := range stmts {
+		result, err := ex.ExecContext(ctx, stmt.query, stmt.args...)
+		if err != nil {
+			countMySQLError(operation)
+			return nil, err
+		}
+		last = result
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			countMySQLError(operation)
+			return nil, err
+		}
+	}
+	return last, nil
+}
+
+// insertBatchSize is the most rows one INSERT statement carries.
+const insertBatchSize = 500
+
+// buildRows renders rows as multi-row INSERT statements. Every row must have
+// the same columns.
+func buildRows(table string, rows []map[string]interface{}) ([]statement, error) {
+	var (
+		stmts []statement
+		cols  []string
+	)
+	for start := 0; start < len(rows); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var (
+			values []string
+			args   []interface{}
+		)
+		for i, row := range rows[start:end] {
+			rowCols, rowArgs, err := assignments(row)
+			if err != nil {
+				return nil, err
+			}
+			if cols == nil {
+				if len(rowCols) == 0 {
+					return nil, invalidf("row %d has no values", start+i+1)
+				}
+				cols = rowCols
+			} else if strings.Join(rowCols, ",") != strings.Join(cols, ",") {
+				return nil, invalidf("row %d has different columns than the first row", start+i+1)
+			}
+			values = append(values, "("+strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")+")")
+			args = append(args, rowArgs...)
+		}
+		stmts = append(stmts, statement{
+			query: fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(cols, ", "), strings.Join(values, ", ")),
+			args:  args,
+		})
+	}
+	return stmts, nil
+}
+
+// buildRepair turns a repair into statements that delete the rows matching
+// Where, or the whole table without it, and insert Rows instead.
+func buildRepair(task ReplicationTask) ([]statement, error) {
+	if err := checkIdent("database", task.DBName); err != nil {
+		return nil, err
+	}
+	if err := checkIdent("table", task.Table); err != nil {
+		return nil, err
+	}
+	table := quoteIdent(task.DBName) + "." + quoteIdent(task.Table)
+
+	del := statement{query: "DELETE FROM " + table}
+	if len(task.Where) > 0 {
+		where, args, err := buildWhere(task.Where)
+		if err != nil {
+			return nil, err
+		}
+		del.query += " WHERE " + where
+		del.args = args
+	}
+	inserts, err := buildRows(table, task.Rows)
+	if err != nil {
+		return nil, err
+	}
+	return append([]statement{del}, inserts...), nil
+}
+
+// maxTransactionOperations is the most operations one transaction may hold.
+const maxTransactionOperations = 1000
+
+// buildTransaction turns the operations of a transaction into statements.
+// Only inserts, updates and deletes are allowed: MySQL commits implicitly
+// before and after creating or dropping databases and tables.
+func buildTransaction(task ReplicationTask) ([]statement, error) {
+	if len(task.Operations) == 0 {
+		return nil, invalidf("operations are required")
+	}
+	if len(task.Operations) > maxTransactionOperations {
+		return nil, invalidf("a transaction holds at most %d operations", maxTransactionOperations)
+	}
+	stmts := make([]statement, 0, len(task.Operations))
+	for i, op := range task.Operations {
+		switch op.Operation {
+		case "insert", "update", "delete":
+		default:
+			return nil, invalidf("operation %d: %q is not allowed in a transaction, use insert, update or delete", i+1, op.Operation)
+		}
+		query, args, err := buildStatement(op)
+		if err != nil {
+			return nil, invalidf("operation %d: %v", i+1, err)
+		}
+		stmts = append(stmts, statement{query: query, args: args})
+	}
+	return stmts, nil
+}
+
+// SelectQuery is a structured read of one table.
+type SelectQuery struct {
+	DBName  string      `json:"dbname"`
+	Table   string      `json:"table"`
+	Columns []string    `json:"columns,omitempty"`
+	Where   []Predicate `json:"where,omitempty"`
+	Limit   int         `json:"limit,omitempty"`
+}
+
+// buildSelect validates a read and turns it into a parameterized statement.
+func buildSelect(q SelectQuery) (string, []interface{}, error) {
+	if err := checkIdent("database", q.DBName); err != nil {
+		return "", nil, err
+	}
+	if err := checkIdent("table", q.Table); err != nil {
+		return "", nil, err
+	}
+
+	cols := "*"
+	if len(q.Columns) > 0 {
+		quoted := make([]string, len(q.Columns))
+		for i, col := range q.Columns {
+			if err := checkIdent("column", col); err != nil {
+				return "", nil, err
+			}
+			quoted[i] = quoteIdent(col)
+		}
+		cols = strings.Join(quoted, ", ")
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s.%s", cols, quoteIdent(q.DBName), quoteIdent(q.Table))
+	var args []interface{}
+	if len(q.Where) > 0 {
+		where, whereArgs, err := buildWhere(q.Where)
+		if err != nil {
+			return "", nil, err
+		}
+		query += " WHERE " + where
+		args = whereArgs
+	}
+	if q.Limit < 0 {
+		return "", nil, invalidf("limit must not be negative")
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	return query, args, nil
+}
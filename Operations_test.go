@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckIdent(t *testing.T) {
+	tests := []struct {
+		kind, name string
+		ok         bool
+	}{
+		{"table", "orders", true},
+		{"table", "_tmp$1", true},
+		{"column", "A1", true},
+		{"table", strings.Repeat("a", 64), true},
+		{"table", strings.Repeat("a", 65), false},
+		{"table", "", false},
+		{"table", "1orders", false},
+		{"table", "orders; DROP TABLE users", false},
+		{"table", "orders`", false},
+		{"column", "id` = 1 OR `x", false},
+		{"column", "a b", false},
+		{"column", "a-b", false},
+		{"column", "a.b", false},
+		{"column", "a'--", false},
+		{"database", "shop\x00", false},
+		{"database", "_replication", false},
+		{"database", "_REPLICATION", false},
+		{"table", "_replication", true},
+	}
+	for _, tt := range tests {
+		err := checkIdent(tt.kind, tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkIdent(%q, %q) = %v, want ok %v", tt.kind, tt.name, err, tt.ok)
+		}
+		var verr *validationError
+		if err != nil && !errors.As(err, &verr) {
+			t.Errorf("checkIdent(%q, %q) returned %T, want *validationError", tt.kind, tt.name, err)
+		}
+	}
+}
+
+func TestBuildStatement(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  ReplicationTask
+		query string
+		args  []interface{}
+		err   string
+	}{
+		{
+			name:  "createdb",
+			task:  ReplicationTask{Operation: "createdb", DBName: "shop"},
+			query: "CREATE DATABASE IF NOT EXISTS `shop`",
+		},
+		{
+			name:  "dropdb",
+			task:  ReplicationTask{Operation: "dropdb", DBName: "shop"},
+			query: "DROP DATABASE IF EXISTS `shop`",
+		},
+		{
+			name: "createtable",
+			task: ReplicationTask{Operation: "createtable", DBName: "shop", Table: "orders", Columns: []ColumnDef{
+				{Name: "id", Type: "int  unsigned", PrimaryKey: true, AutoIncrement: true},
+				{Name: "item", Type: "varchar(255)", NotNull: true, Unique: true},
+			}},
+			query: "CREATE TABLE IF NOT EXISTS `shop`.`orders` (`id` int unsigned NOT NULL AUTO_INCREMENT, `item` varchar(255) NOT NULL UNIQUE, PRIMARY KEY (`id`))",
+		},
+		{
+			name:  "insert",
+			task:  ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"qty": json.Number("3"), "item": "pen"}},
+			query: "INSERT INTO `shop`.`orders` (`item`, `qty`) VALUES (?, ?)",
+			args:  []interface{}{"pen", "3"},
+		},
+		{
+			name: "update",
+			task: ReplicationTask{Operation: "update", DBName: "shop", Table: "orders",
+				Set:   map[string]interface{}{"qty": 4},
+				Where: []Predicate{{Column: "id", Op: "=", Value: 1}}},
+			query: "UPDATE `shop`.`orders` SET `qty` = ? WHERE `id` = ?",
+			args:  []interface{}{4, 1},
+		},
+		{
+			name:  "delete",
+			task:  ReplicationTask{Operation: "delete", DBName: "shop", Table: "orders", Where: []Predicate{{Column: "id", Op: "in", Values: []interface{}{1, 2}}}},
+			query: "DELETE FROM `shop`.`orders` WHERE `id` IN (?, ?)",
+			args:  []interface{}{1, 2},
+		},
+		{
+			name:  "values are bound, not inlined",
+			task:  ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"item": "'); DROP TABLE orders; --"}},
+			query: "INSERT INTO `shop`.`orders` (`item`) VALUES (?)",
+			args:  []interface{}{"'); DROP TABLE orders; --"},
+		},
+		{
+			name: "injection in database name",
+			task: ReplicationTask{Operation: "createdb", DBName: "shop; DROP DATABASE mysql"},
+			err:  "invalid database name",
+		},
+		{
+			name: "injection in table name",
+			task: ReplicationTask{Operation: "delete", DBName: "shop", Table: "orders` WHERE 1=1 --", Where: []Predicate{{Column: "id", Op: "=", Value: 1}}},
+			err:  "invalid table name",
+		},
+		{
+			name: "injection in column name",
+			task: ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"item`) VALUES (1); --": "x"}},
+			err:  "invalid column name",
+		},
+		{
+			name: "injection in column type",
+			task: ReplicationTask{Operation: "createtable", DBName: "shop", Table: "orders", Columns: []ColumnDef{{Name: "id", Type: "INT); DROP TABLE users; --"}}},
+			err:  "unsupported column type",
+		},
+		{
+			name: "reserved database",
+			task: ReplicationTask{Operation: "dropdb", DBName: "_replication"},
+			err:  "is reserved",
+		},
+		{
+			name: "update without where",
+			task: ReplicationTask{Operation: "update", DBName: "shop", Table: "orders", Set: map[string]interface{}{"qty": 4}},
+			err:  "where is required",
+		},
+		{
+			name: "delete without where",
+			task: ReplicationTask{Operation: "delete", DBName: "shop", Table: "orders"},
+			err:  "where is required",
+		},
+		{
+			name: "insert without values",
+			task: ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders"},
+			err:  "values are required",
+		},
+		{
+			name: "nested value",
+			task: ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"item": []interface{}{"a"}}},
+			err:  "must be a string",
+		},
+		{
+			name: "unknown operation",
+			task: ReplicationTask{Operation: "truncate", DBName: "shop", Table: "orders"},
+			err:  "unknown operation",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := buildStatement(tt.task)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				var verr *validationError
+				if !errors.As(err, &verr) {
+					t.Errorf("err is %T, want *validationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.query {
+				t.Errorf("query = %q, want %q", query, tt.query)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBuildWhere(t *testing.T) {
+	tests := []struct {
+		name  string
+		preds []Predicate
+		where string
+		args  []interface{}
+		err   string
+	}{
+		{
+			name:  "single",
+			preds: []Predicate{{Column: "id", Op: "=", Value: 1}},
+			where: "`id` = ?",
+			args:  []interface{}{1},
+		},
+		{
+			name:  "and",
+			preds: []Predicate{{Column: "a", Op: ">=", Value: 1}, {Column: "b", Op: "not  LIKE", Value: "x%"}},
+			where: "(`a` >= ? AND `b` NOT LIKE ?)",
+			args:  []interface{}{1, "x%"},
+		},
+		{
+			name: "nested or",
+			preds: []Predicate{
+				{Column: "a", Op: "is null"},
+				{Or: []Predicate{{Column: "b", Op: "<", Value: 2}, {Column: "c", Op: "not in", Values: []interface{}{"x", "y"}}}},
+			},
+			where: "(`a` IS NULL AND (`b` < ? OR `c` NOT IN (?, ?)))",
+			args:  []interface{}{2, "x", "y"},
+		},
+		{
+			name:  "value that looks like SQL",
+			preds: []Predicate{{Column: "name", Op: "=", Value: "x' OR '1'='1"}},
+			where: "`name` = ?",
+			args:  []interface{}{"x' OR '1'='1"},
+		},
+		{
+			name:  "binary value",
+			preds: []Predicate{{Column: "b", Op: "=", Value: map[string]interface{}{"$binary": "AP8="}}},
+			where: "`b` = ?",
+			args:  []interface{}{[]byte{0x00, 0xff}},
+		},
+		{
+			name:  "operator injection",
+			preds: []Predicate{{Column: "id", Op: "= 1 OR 1 =", Value: 1}},
+			err:   "unsupported operator",
+		},
+		{
+			name:  "column injection",
+			preds: []Predicate{{Column: "id = 1 OR 1", Op: "=", Value: 1}},
+			err:   "invalid column name",
+		},
+		{
+			name:  "null comparison",
+			preds: []Predicate{{Column: "id", Op: "="}},
+			err:   "needs a value",
+		},
+		{
+			name:  "empty in",
+			preds: []Predicate{{Column: "id", Op: "in"}},
+			err:   "non-empty values list",
+		},
+		{
+			name:  "invalid base64",
+			preds: []Predicate{{Column: "b", Op: "=", Value: map[string]interface{}{"$binary": "not base64!"}}},
+			err:   "not valid base64",
+		},
+		{
+			name:  "empty",
+			preds: nil,
+			err:   "empty predicate group",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args, err := buildWhere(tt.preds)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tt.where {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBuildRows(t *testing.T) {
+	many := make([]map[string]interface{}, insertBatchSize+1)
+	for i := range many {
+		many[i] = map[string]interface{}{"id": i}
+	}
+
+	tests := []struct {
+		name    string
+		rows    []map[string]interface{}
+		queries []string
+		args    int
+		err     string
+	}{
+		{
+			name:    "one statement",
+			rows:    []map[string]interface{}{{"id": 1, "item": "pen"}, {"item": "ink", "id": 2}},
+			queries: []string{"INSERT INTO t (`id`, `item`) VALUES (?, ?), (?, ?)"},
+			args:    4,
+		},
+		{
+			name: "batches",
+			rows: many,
+			queries: []string{
+				"INSERT INTO t (`id`) VALUES " + strings.TrimSuffix(strings.Repeat("(?), ", insertBatchSize), ", "),
+				"INSERT INTO t (`id`) VALUES (?)",
+			},
+			args: insertBatchSize + 1,
+		},
+		{
+			name: "different columns",
+			rows: []map[string]interface{}{{"id": 1}, {"item": "pen"}},
+			err:  "row 2 has different columns",
+		},
+		{
+			name: "empty row",
+			rows: []map[string]interface{}{{}},
+			err:  "row 1 has no values",
+		},
+		{
+			name: "column injection",
+			rows: []map[string]interface{}{{"id`) VALUES (1); DROP TABLE t; --": 1}},
+			err:  "invalid column name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmts, err := buildRows("t", tt.rows)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var queries []string
+			args := 0
+			for _, stmt := range stmts {
+				queries = append(queries, stmt.query)
+				args += len(stmt.args)
+			}
+			if !reflect.DeepEqual(queries, tt.queries) {
+				t.Errorf("queries = %q, want %q", queries, tt.queries)
+			}
+			if args != tt.args {
+				t.Errorf("%d args, want %d", args, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Read routing: every node serves the read endpoints from its own database.
+// A client can bound how stale a slave's answer may be, and a slave that is
+// too far behind forwards the read to the master or refuses it.
+
+const (
+	appliedLSNHeader = "X-Applied-LSN"
+	lastLSNHeader    = "X-Last-LSN"
+	forwardedHeader  = "X-Read-Forwarded"
+	tokenHeader      = "X-Consistency-Token"
+)
+
+const (
+	defaultReadWait = time.Second
+	maxReadWait     = 30 * time.Second
+)
+
+// masterReport is a position the master's log had at a point in time.
+type masterReport struct {
+	lsn uint64
+	at  time.Time
+}
+
+const maxPendingReports = 64
+
+var (
+	freshMu sync.Mutex
+	// freshAsOf is the latest time at which this slave is known to have held
+	// everything the master had committed.
+	freshAsOf time.Time
+	// pendingReports are master positions this slave has not applied yet,
+	// oldest first.
+	pendingReports []masterReport
+)
+
+// noteMasterPosition records that the master's log ended at lsn at time at.
+// Slaves learn it from registrations, heartbeats, pushed entries and
+// catch-up responses.
+func noteMasterPosition(lsn uint64, at time.Time) {
+	freshMu.Lock()
+	defer freshMu.Unlock()
+
+	if lsn <= replicationLog.LastLSN() {
+		if at.After(freshAsOf) {
+			freshAsOf = at
+		}
+		return
+	}
+	if n := len(pendingReports); n > 0 {
+		last := &pendingReports[n-1]
+		switch {
+		case last.lsn == lsn:
+			// The master still had lsn at the later time.
+			last.at = at
+			return
+		case last.lsn > lsn:
+			// A different master, after a failover; start over.
+			pendingReports = nil
+		}
+	}
+	if len(pendingReports) == maxPendingReports {
+		// Dropping the oldest report only makes the estimate more pessimistic.
+		pendingReports = pendingReports[1:]
+	}
+	pendingReports = append(pendingReports, masterReport{lsn: lsn, at: at})
+}
+
+// staleness returns how far this node may be behind the master in time. The
+// master itself is never stale; a slave that has not heard from any master
+// yet does not know its staleness.
+func staleness() (time.Duration, bool) {
+	if isCurrentMaster() {
+		return 0, true
+	}
+	applied := replicationLog.LastLSN()
+
+	freshMu.Lock()
+	defer freshMu.Unlock()
+	for len(pendingReports) > 0 && pendingReports[0].lsn <= applied {
+		if pendingReports[0].at.After(freshAsOf) {
+			freshAsOf = pendingReports[0].at
+		}
+		pendingReports = pendingReports[1:]
+	}
+	if freshAsOf.IsZero() {
+		return 0, false
+	}
+	return time.Since(freshAsOf), true
+}
+
+// checkFreshness returns why this node cannot serve a read with the bounds in
+// r, or "" if it can. max_staleness is in seconds, min_lsn is the lowest LSN
+// the node must have applied.
+func checkFreshness(r *http.Request) (string, error) {
+	query := r.URL.Query()
+	if v := query.Get("min_lsn"); v != "" {
+		minLSN, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return "", invalidf("min_lsn must be an LSN")
+		}
+		if applied := replicationLog.LastLSN(); applied < minLSN {
+			return fmt.Sprintf("applied LSN %d is below %d", applied, minLSN), nil
+		}
+	}
+	if v := query.Get("max_staleness"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil || seconds < 0 {
+			return "", invalidf("max_staleness must be a number of seconds")
+		}
+		lag, known := staleness()
+		if !known {
+			return "staleness is unknown until the master has been reached", nil
+		}
+		if limit := time.Duration(seconds * float64(time.Second)); lag > limit {
+			return fmt.Sprintf("data may be %v old", lag.Round(time.Millisecond)), nil
+		}
+	}
+	return "", nil
+}
+
+// readRoute serves a read from the local database. On a slave it first waits
+// briefly for the write named by a consistency token and checks the client's
+// freshness bounds. A read the slave cannot serve is redirected (token) or
+// forwarded (bounds) to the master or, with on_stale=refuse (or the
+// stale_reads setting), refused.
+func readRoute(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(appliedLSNHeader, strconv.FormatUint(replicationLog.LastLSN(), 10))
+		if isCurrentMaster() {
+			h(w, r)
+			return
+		}
+
+		policy := r.URL.Query().Get("on_stale")
+		if policy == "" {
+			policy = cfg.StaleReads
+		}
+		master := currentMaster()
+		refuse := func(reason string) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"error":  "this node is too far behind: " + reason,
+				"code":   "stale_read",
+				"lsn":    replicationLog.LastLSN(),
+				"master": master,
+			})
+		}
+
+		token, wait, err := readToken(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid consistency token", err)
+			return
+		}
+		if token > 0 && !waitForLSN(token, wait) {
+			if policy == "refuse" || master == "" || r.Header.Get(forwardedHeader) != "" {
+				refuse(fmt.Sprintf("LSN %d was not applied within %v", token, wait))
+				return
+			}
+			// The master has every write it acknowledged; send the client there.
+			w.Header().Set("Location", master+r.URL.RequestURI())
+			writeJSON(w, http.StatusTemporaryRedirect, map[string]interface{}{
+				"message": fmt.Sprintf("LSN %d is not applied here yet, read from the master", token),
+				"master":  master,
+			})
+			return
+		}
+
+		reason, err := checkFreshness(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid read bounds", err)
+			return
+		}
+		if reason == "" {
+			h(w, r)
+			return
+		}
+		if policy == "refuse" || master == "" || r.Header.Get(forwardedHeader) != "" {
+			refuse(reason)
+			return
+		}
+		forwardRead(w, r, master)
+	}
+}
+
+// forwardFiltered sends a read of data this slave's replication filter leaves
+// out to the master, or refuses it when there is no master to ask, and reports
+// whether it did. The master holds everything, so it never forwards.
+func forwardFiltered(w http.ResponseWriter, r *http.Request, excluded bool, what string) bool {
+	if !excluded || isCurrentMaster() {
+		return false
+	}
+	master := currentMaster()
+	if master == "" || r.Header.Get(forwardedHeader) != "" {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"error":  "this node does not replicate " + what,
+			"code":   "filtered",
+			"master": master,
+		})
+		return true
+	}
+	forwardRead(w, r, master)
+	return true
+}
+
+// readToken returns the consistency token of a read, taken from the token
+// parameter or the X-Consistency-Token header, and how long the read may
+// wait for it (wait_ms, or the read_wait_ms setting).
+func readToken(r *http.Request) (uint64, time.Duration, error) {
+	v := r.URL.Query().Get("token")
+	if v == "" {
+		v = r.Header.Get(tokenHeader)
+	}
+	if v == "" {
+		return 0, 0, nil
+	}
+	token, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, 0, invalidf("token must be the LSN returned by a write")
+	}
+
+	wait := defaultReadWait
+	if cfg.ReadWaitMS > 0 {
+		wait = time.Duration(cfg.ReadWaitMS) * time.Millisecond
+	}
+	if v := r.URL.Query().Get("wait_ms"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return 0, 0, invalidf("wait_ms must be a number of milliseconds")
+		}
+		wait = time.Duration(ms) * time.Millisecond
+	}
+	if wait > maxReadWait {
+		wait = maxReadWait
+	}
+	return token, wait, nil
+}
+
+// waitForLSN blocks until this node has applied lsn or timeout expires, and
+// reports whether lsn was applied.
+func waitForLSN(lsn uint64, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		applied := replicationLog.LastLSN()
+		if applied >= lsn {
+			return true
+		}
+		select {
+		case <-replicationLog.Wait(applied):
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// forwardRead proxies a read to the master. The request is marked so that it
+// is never forwarded a second time.
+func forwardRead(w http.ResponseWriter, r *http.Request, master string) {
+	target, err := url.Parse(master)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Invalid master address", err)
+		return
+	}
+	r.Header.Set(forwardedHeader, cfg.Advertise)
+	w.Header().Del(appliedLSNHeader)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = clientTransport{}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		writeError(w, http.StatusBadGateway, "Failed to forward read to the master", err)
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func defineReadRoutes() {
+	http.HandleFunc("/select", readRoute(selectRecords))
+	http.HandleFunc("/databases", readRoute(listDatabases))
+	http.HandleFunc("/tables", readRoute(listTables))
+}
+
+// selectRecords reads a table. GET takes the dbname and table query
+// parameters; POST takes a SelectQuery with optional columns, predicates and
+// limit.
+func selectRecords(w http.ResponseWriter, r *http.Request) {
+	q := SelectQuery{
+		DBName: r.URL.Query().Get("dbname"),
+		Table:  r.URL.Query().Get("table"),
+	}
+	if r.Method == http.MethodPost {
+		if err := decodeJSON(r.Body, &q); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+	}
+
+	if !authorized(w, r, q.DBName, roleReadOnly) {
+		return
+	}
+	excluded := !cfg.ReplicationFilter.allowsTable(q.DBName, q.Table)
+	if excluded && r.Method == http.MethodPost {
+		// The body has been read; forward the query as decoded.
+		body, err := json.Marshal(q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to encode query", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+	if forwardFiltered(w, r, excluded, q.DBName+"."+q.Table) {
+		return
+	}
+
+	query, args, err := buildSelect(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid query", err)
+		return
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		countMySQLError("select")
+		writeError(w, http.StatusInternalServerError, "Failed to query records", err)
+		return
+	}
+	defer rows.Close()
+
+	_, results, err := scanRows(rows)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read records", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// listDatabases returns the names of the databases on this node, leaving out
+// MySQL's own and the replication state.
+func listDatabases(w http.ResponseWriter, r *http.Request) {
+	// A filtered slave only has some of the databases.
+	if forwardFiltered(w, r, cfg.ReplicationFilter.active(), "every database") {
+		return
+	}
+	all, err := queryNames("SHOW DATABASES")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list databases", err)
+		return
+	}
+	names := []string{}
+	for _, name := range all {
+		if !systemDatabases[strings.ToLower(name)] {
+			names = append(names, name)
+		}
+	}
+	writeJSON(w, http.StatusOK, readableDatabases(r, names))
+}
+
+// listTables returns the tables of the database in the dbname parameter.
+func listTables(w http.ResponseWriter, r *http.Request) {
+	dbname := r.URL.Query().Get("dbname")
+	if err := checkIdent("database", dbname); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid database", err)
+		return
+	}
+	if !authorized(w, r, dbname, roleReadOnly) {
+		return
+	}
+	if forwardFiltered(w, r, cfg.ReplicationFilter.active(), "every table of "+dbname) {
+		return
+	}
+	names, err := queryNames("SHOW TABLES FROM " + quoteIdent(dbname))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list tables", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+// queryNames runs a query that returns one name per row.
+func queryNames(query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		countMySQLError("show")
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSlaveLog makes this node a slave that has applied a log of n entries
+// and has not heard from a master yet.
+func withSlaveLog(t *testing.T, n int) {
+	t.Helper()
+	savedLog, savedMaster := replicationLog, isMaster
+	savedFresh, savedReports := freshAsOf, pendingReports
+	t.Cleanup(func() {
+		replicationLog, isMaster = savedLog, savedMaster
+		freshAsOf, pendingReports = savedFresh, savedReports
+	})
+	replicationLog, _ = testLog(t, n)
+	isMaster = false
+	freshAsOf, pendingReports = time.Time{}, nil
+}
+
+func TestNoteMasterPosition(t *testing.T) {
+	withSlaveLog(t, 5)
+	base := time.Now().Add(-time.Hour)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	if _, known := staleness(); known {
+		t.Fatal("staleness known before any report")
+	}
+
+	steps := []struct {
+		name    string
+		lsn     uint64
+		at      time.Time
+		append  int // entries the slave applies after the report
+		fresh   time.Time
+		pending []masterReport
+	}{
+		{"caught up", 5, at(0), 0, at(0), nil},
+		{"behind", 7, at(1), 0, at(0), []masterReport{{7, at(1)}}},
+		{"same position later", 7, at(2), 0, at(0), []masterReport{{7, at(2)}}},
+		{"further behind", 9, at(3), 0, at(0), []masterReport{{7, at(2)}, {9, at(3)}}},
+		{"applied the first", 9, at(3), 2, at(2), []masterReport{{9, at(3)}}},
+		{"new master starts lower", 8, at(4), 0, at(2), []masterReport{{8, at(4)}}},
+		{"applied everything", 8, at(4), 2, at(4), nil},
+		{"older report", 6, at(1), 0, at(4), nil},
+	}
+	for _, step := range steps {
+		noteMasterPosition(step.lsn, step.at)
+		for i := 0; i < step.append; i++ {
+			if _, err := replicationLog.Append(3, ReplicationTask{Operation: "skip"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lag, known := staleness()
+		if !known || !freshAsOf.Equal(step.fresh) {
+			t.Errorf("%s: fresh as of %v (known %v), want %v", step.name, freshAsOf, known, step.fresh)
+		}
+		if want := time.Since(step.fresh); lag > want || lag < want-time.Second {
+			t.Errorf("%s: staleness %v, want about %v", step.name, lag, want)
+		}
+		if len(pendingReports) != len(step.pending) {
+			t.Errorf("%s: pending %v, want %v", step.name, pendingReports, step.pending)
+			continue
+		}
+		for i, report := range pendingReports {
+			if report.lsn != step.pending[i].lsn || !report.at.Equal(step.pending[i].at) {
+				t.Errorf("%s: pending %v, want %v", step.name, pendingReports, step.pending)
+			}
+		}
+	}
+}
+
+func TestNoteMasterPositionKeepsRecentReports(t *testing.T) {
+	withSlaveLog(t, 0)
+	start := time.Now()
+	for i := 1; i <= maxPendingReports+10; i++ {
+		noteMasterPosition(uint64(i), start.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(pendingReports) != maxPendingReports || pendingReports[0].lsn != 11 {
+		t.Errorf("kept %d reports from LSN %d, want %d from 11", len(pendingReports), pendingReports[0].lsn, maxPendingReports)
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		fresh  time.Duration // how long ago the slave was known to be current, 0 for never
+		reason string
+		err    bool
+	}{
+		{"no bounds", "", 0, "", false},
+		{"min_lsn applied", "min_lsn=5", 0, "", false},
+		{"min_lsn ahead", "min_lsn=6", 0, "applied LSN 5 is below 6", false},
+		{"min_lsn invalid", "min_lsn=x", 0, "", true},
+		{"fresh enough", "max_staleness=10", 2 * time.Second, "", false},
+		{"fractional bound", "max_staleness=0.5", 2 * time.Second, "old", false},
+		{"too stale", "max_staleness=1", 2 * time.Second, "old", false},
+		{"staleness unknown", "max_staleness=10", 0, "staleness is unknown", false},
+		{"negative bound", "max_staleness=-1", time.Second, "", true},
+		{"invalid bound", "max_staleness=soon", time.Second, "", true},
+		{"both bounds", "min_lsn=6&max_staleness=10", time.Second, "applied LSN 5 is below 6", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSlaveLog(t, 5)
+			if tt.fresh > 0 {
+				freshAsOf = time.Now().Add(-tt.fresh)
+			}
+			reason, err := checkFreshness(httptest.NewRequest(http.MethodGet, "/select?"+tt.query, nil))
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if (reason == "") != (tt.reason == "") || !strings.Contains(reason, tt.reason) {
+				t.Errorf("reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckFreshnessOnMaster(t *testing.T) {
+	withSlaveLog(t, 5)
+	isMaster = true
+	reason, err := checkFreshness(httptest.NewRequest(http.MethodGet, "/select?max_staleness=0", nil))
+	if err != nil || reason != "" {
+		t.Errorf("checkFreshness on the master = %q, %v", reason, err)
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		header string
+		config int // read_wait_ms
+		token  uint64
+		wait   time.Duration
+		err    bool
+	}{
+		{"no token", "", "", 0, 0, 0, false},
+		{"parameter", "token=12", "", 0, 12, defaultReadWait, false},
+		{"header", "", "7", 0, 7, defaultReadWait, false},
+		{"parameter wins", "token=12", "7", 0, 12, defaultReadWait, false},
+		{"configured wait", "token=12", "", 250, 12, 250 * time.Millisecond, false},
+		{"requested wait", "token=12&wait_ms=50", "", 250, 12, 50 * time.Millisecond, false},
+		{"no wait", "token=12&wait_ms=0", "", 0, 12, 0, false},
+		{"wait capped", "token=12&wait_ms=3600000", "", 0, 12, maxReadWait, false},
+		{"invalid token", "token=abc", "", 0, 0, 0, true},
+		{"negative wait", "token=12&wait_ms=-1", "", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := cfg
+			t.Cleanup(func() { cfg = saved })
+			cfg.ReadWaitMS = tt.config
+
+			r := httptest.NewRequest(http.MethodGet, "/select?"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set(tokenHeader, tt.header)
+			}
+			token, wait, err := readToken(r)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if token != tt.token || wait != tt.wait {
+				t.Errorf("readToken = %d, %v, want %d, %v", token, wait, tt.token, tt.wait)
+			}
+		})
+	}
+}
+
+func TestWaitForLSN(t *testing.T) {
+	withSlaveLog(t, 3)
+	if !waitForLSN(3, 0) {
+		t.Error("waitForLSN(applied) = false")
+	}
+	if waitForLSN(4, 10*time.Millisecond) {
+		t.Error("waitForLSN(4) = true before it was applied")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		replicationLog.Append(2, ReplicationTask{Operation: "skip"})
+	}()
+	start := time.Now()
+	if !waitForLSN(4, 5*time.Second) {
+		t.Error("waitForLSN(4) = false after it was applied")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waitForLSN returned after %v, want as soon as LSN 4 was applied", waited)
+	}
+}
+
+func TestListDatabasesHidesSystemDatabases(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.ReplicationFilter, cfg.Auth = ReplicationFilter{}, AuthConfig{}
+	withFakeDB(t, func(query string, args []interface{}) (driver.Result, *fakeRows, error) {
+		var values [][]driver.Value
+		for _, name := range []string{"information_schema", "_replication", "mysql", "crm", "performance_schema", "shop", "sys", "SYS"} {
+			values = append(values, []driver.Value{name})
+		}
+		return nil, &fakeRows{columns: []string{"Database"}, values: values}, nil
+	})
+
+	w := httptest.NewRecorder()
+	listDatabases(w, httptest.NewRequest(http.MethodGet, "/databases", nil))
+	if got := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || got != `["crm","shop"]` {
+		t.Errorf("got %d %s, want [\"crm\",\"shop\"]", w.Code, got)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogEntry is one committed write in the replication log. LSNs start at 1 and
+// increase by one for every entry. Term is the election term of the master
+// that committed the write.
+type LogEntry struct {
+	LSN  uint64          `json:"lsn"`
+	Term uint64          `json:"term"`
+	Time time.Time       `json:"time"`
+	Task ReplicationTask `json:"task"`
+}
+
+// ReplicationLog is an append-only file of JSON encoded LogEntry values, one
+// per line. Every append is synced to disk before it becomes visible.
+//
+// A log does not have to start at LSN 1: a slave that was bootstrapped from a
+// snapshot starts its log at the snapshot's LSN, its base.
+type ReplicationLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	basePath string
+	base     uint64  // LSN of the state the log starts from
+	offsets  []int64 // offsets[i] is the file offset of the entry with LSN base+i+1
+	times    []int64 // times[i] is the time of that entry, in Unix nanoseconds
+	size     int64
+	lastLSN  uint64
+	lastTerm uint64
+	notify   chan struct{} // closed and replaced on every append
+}
+
+// errLogCompacted is returned when entries are requested from before the
+// start of the log. The reader needs a snapshot instead.
+var errLogCompacted = errors.New("requested entries are older than the replication log")
+
+var replicationLog *ReplicationLog
+
+// entryBatchBytes bounds how much of the log is read into memory at once
+// for replication, since a single bulk insert can be megabytes.
+const entryBatchBytes = 8 << 20
+
+// openReplicationLog opens (or creates) the log in dir and indexes the
+// existing entries. A torn entry at the end of the file, left behind by a
+// crash in the middle of an append, is truncated away.
+func openReplicationLog(dir string) (*ReplicationLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "replication.log"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := filepath.Join(dir, "replication.base")
+	base, err := readLSNFile(basePath)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &ReplicationLog{file: f, basePath: basePath, base: base, lastLSN: base, notify: make(chan struct{})}
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		var entry LogEntry
+		if json.Unmarshal(line, &entry) != nil || entry.LSN != l.lastLSN+1 {
+			break
+		}
+		l.offsets = append(l.offsets, offset)
+		l.times = append(l.times, entry.Time.UnixNano())
+		l.lastLSN = entry.LSN
+		l.lastTerm = entry.Term
+		offset += int64(len(line))
+	}
+
+	if info, err := f.Stat(); err == nil && info.Size() > offset {
+		slog.Warn("Truncating incomplete replication log", "bytes", info.Size()-offset)
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.size = offset
+	return l, nil
+}
+
+// Append assigns the next LSN to task and writes it durably to the log,
+// stamped with the master's term.
+func (l *ReplicationLog) Append(term uint64, task ReplicationTask) (LogEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := LogEntry{LSN: l.lastLSN + 1, Term: term, Time: time.Now().UTC(), Task: task}
+	if err := l.write(entry); err != nil {
+		return LogEntry{}, err
+	}
+	return entry, nil
+}
+
+// AppendEntry stores an entry that was assigned its LSN by another node. Slaves
+// use it to keep a copy of the master's log; the entry must directly follow
+// the last one in the log.
+func (l *ReplicationLog) AppendEntry(entry LogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.LSN != l.lastLSN+1 {
+		return fmt.Errorf("out of order log entry: got LSN %d, expected %d", entry.LSN, l.lastLSN+1)
+	}
+	return l.write(entry)
+}
+
+func (l *ReplicationLog) write(entry LogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := l.file.Write(line); err != nil {
+		l.file.Truncate(l.size)
+		l.file.Seek(l.size, io.SeekStart)
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.offsets = append(l.offsets, l.size)
+	l.times = append(l.times, entry.Time.UnixNano())
+	l.size += int64(len(line))
+	l.lastLSN = entry.LSN
+	l.lastTerm = entry.Term
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return nil
+}
+
+// LastLSN returns the LSN of the newest entry, or 0 if the log is empty.
+func (l *ReplicationLog) LastLSN() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastLSN
+}
+
+// LastTerm returns the term of the newest entry, or 0 if the log is empty.
+func (l *ReplicationLog) LastTerm() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastTerm
+}
+
+// TermAt returns the term of the entry at lsn. It returns errLogCompacted for
+// the base and anything before it, whose terms are not known.
+func (l *ReplicationLog) TermAt(lsn uint64) (uint64, error) {
+	l.mu.Lock()
+	if lsn <= l.base {
+		l.mu.Unlock()
+		return 0, errLogCompacted
+	}
+	if lsn > l.lastLSN {
+		l.mu.Unlock()
+		return 0, fmt.Errorf("LSN %d is not in the log yet", lsn)
+	}
+	if lsn == l.lastLSN {
+		term := l.lastTerm
+		l.mu.Unlock()
+		return term, nil
+	}
+	l.mu.Unlock()
+
+	entries, err := l.Entries(lsn-1, 1, 0)
+	if err != nil {
+		return 0, err
+	}
+	return entries[0].Term, nil
+}
+
+// TimeAt returns when the entry at lsn was committed, from the index, without
+// reading the entry.
+func (l *ReplicationLog) TimeAt(lsn uint64) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lsn <= l.base || lsn > l.lastLSN {
+		return time.Time{}, false
+	}
+	return time.Unix(0, l.times[lsn-l.base-1]), true
+}
+
+// Wait returns a channel that is closed once the log holds an entry newer
+// than after.
+func (l *ReplicationLog) Wait(after uint64) <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lastLSN > after {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return l.notify
+}
+
+// Entries returns up to limit entries with an LSN greater than after, in
+// order. With maxBytes > 0 it stops before the entries take more than
+// maxBytes in the log, but always returns at least one.
+func (l *ReplicationLog) Entries(after uint64, limit int, maxBytes int64) ([]LogEntry, error) {
+	l.mu.Lock()
+	if after < l.base {
+		l.mu.Unlock()
+		return nil, errLogCompacted
+	}
+	if after >= l.lastLSN {
+		l.mu.Unlock()
+		return nil, nil
+	}
+	first := int(after - l.base)
+	start := l.offsets[first]
+	count := int(l.lastLSN - after)
+	if limit > 0 && count > limit {
+		count = limit
+	}
+	// end returns the offset just past the first n entries.
+	end := func(n int) int64 {
+		if first+n < len(l.offsets) {
+			return l.offsets[first+n]
+		}
+		return l.size
+	}
+	for maxBytes > 0 && count > 1 && end(count)-start > maxBytes {
+		count--
+	}
+	stop := end(count)
+	l.mu.Unlock()
+
+	// Entries that are already indexed never change, so they can be read
+	// without holding the lock.
+	reader := bufio.NewReader(io.NewSectionReader(l.file, start, stop-start))
+	entries := make([]LogEntry, 0, count)
+	for len(entries) < count {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading replication log: %w", err)
+		}
+		var entry LogEntry
+		if err := decodeJSON(bytes.NewReader(line), &entry); err != nil {
+			return nil, fmt.Errorf("decoding replication log: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Base returns the LSN the log starts from. Entries up to and including the
+// base are not available from this log.
+func (l *ReplicationLog) Base() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.base
+}
+
+// Reset discards every entry and restarts the log at base. It is used after a
+// snapshot taken at base has been loaded.
+func (l *ReplicationLog) Reset(base uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The base is written first: if we crash before the truncate, the old
+	// entries no longer follow the base and are discarded on the next open.
+	if err := writeLSNFile(l.basePath, base); err != nil {
+		return err
+	}
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.base = base
+	l.offsets = nil
+	l.times = nil
+	l.size = 0
+	l.lastLSN = base
+	l.lastTerm = 0
+	close(l.notify)
+	l.notify = make(chan struct{})
+	return nil
+}
+
+// Close closes the underlying file.
+func (l *ReplicationLog) Close() error {
+	return l.file.Close()
+}
+
+// readLSNFile reads an LSN stored by writeLSNFile. A missing file reads as 0.
+func readLSNFile(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var lsn uint64
+	if _, err := fmt.Sscan(string(data), &lsn); err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return lsn, nil
+}
+
+// writeLSNFile atomically replaces the LSN stored at path.
+func writeLSNFile(path string, lsn uint64) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", lsn)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
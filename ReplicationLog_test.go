@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testLog opens a log in a temporary directory with n entries, the i-th in
+// term i/2+1.
+func testLog(t *testing.T, n int) (*ReplicationLog, string) {
+	t.Helper()
+	dir := t.TempDir()
+	l, err := openReplicationLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	for i := 0; i < n; i++ {
+		task := ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders", Values: map[string]interface{}{"n": float64(i)}}
+		if _, err := l.Append(uint64(i/2+1), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return l, dir
+}
+
+func reopen(t *testing.T, l *ReplicationLog, dir string) *ReplicationLog {
+	t.Helper()
+	l.Close()
+	l, err := openReplicationLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReplicationLogReopen(t *testing.T) {
+	l, dir := testLog(t, 5)
+	if l.LastLSN() != 5 || l.LastTerm() != 3 {
+		t.Fatalf("last = %d/%d, want 5/3", l.LastLSN(), l.LastTerm())
+	}
+
+	l = reopen(t, l, dir)
+	if l.LastLSN() != 5 || l.LastTerm() != 3 {
+		t.Fatalf("after reopen last = %d/%d, want 5/3", l.LastLSN(), l.LastTerm())
+	}
+	entries, err := l.Entries(0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if entry.LSN != uint64(i+1) || entry.Task.Values["n"] != json.Number(strconv.Itoa(i)) {
+			t.Errorf("entry %d = LSN %d values %v", i, entry.LSN, entry.Task.Values)
+		}
+	}
+	if _, ok := l.TimeAt(5); !ok {
+		t.Error("TimeAt(5) not known after reopen")
+	}
+
+	entry, err := l.Append(4, ReplicationTask{Operation: "skip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.LSN != 6 {
+		t.Errorf("append after reopen got LSN %d, want 6", entry.LSN)
+	}
+}
+
+func TestReplicationLogTruncatesTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{"partial line", `{"lsn":4,"term":3,"ti`},
+		{"garbage", "\x00\x00\x00\n"},
+		{"out of order entry", `{"lsn":7,"term":3,"time":"2024-01-01T00:00:00Z","task":{"operation":"skip","dbname":""}}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, dir := testLog(t, 3)
+			l.Close()
+			path := filepath.Join(dir, "replication.log")
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, append(before, tt.tail...), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			l, err = openReplicationLog(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if l.LastLSN() != 3 {
+				t.Errorf("LastLSN = %d, want 3", l.LastLSN())
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(after) != string(before) {
+				t.Errorf("log is %d bytes after open, want %d", len(after), len(before))
+			}
+			if _, err := l.Append(3, ReplicationTask{Operation: "skip"}); err != nil {
+				t.Fatal(err)
+			}
+			if entries, err := l.Entries(3, 0, 0); err != nil || len(entries) != 1 || entries[0].LSN != 4 {
+				t.Errorf("Entries(3) = %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestReplicationLogReset(t *testing.T) {
+	l, dir := testLog(t, 3)
+	if err := l.Reset(10); err != nil {
+		t.Fatal(err)
+	}
+	if l.Base() != 10 || l.LastLSN() != 10 || l.LastTerm() != 0 {
+		t.Fatalf("after reset base %d last %d/%d, want 10 10/0", l.Base(), l.LastLSN(), l.LastTerm())
+	}
+	if _, err := l.Entries(5, 0, 0); err != errLogCompacted {
+		t.Errorf("Entries before base: err = %v, want errLogCompacted", err)
+	}
+	if _, err := l.TermAt(10); err != errLogCompacted {
+		t.Errorf("TermAt(base): err = %v, want errLogCompacted", err)
+	}
+	if err := l.AppendEntry(LogEntry{LSN: 12, Term: 5}); err == nil {
+		t.Error("AppendEntry accepted a gap")
+	}
+	if err := l.AppendEntry(LogEntry{LSN: 11, Term: 5, Task: ReplicationTask{Operation: "skip"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	l = reopen(t, l, dir)
+	if l.Base() != 10 || l.LastLSN() != 11 || l.LastTerm() != 5 {
+		t.Errorf("after reopen base %d last %d/%d, want 10 11/5", l.Base(), l.LastLSN(), l.LastTerm())
+	}
+}
+
+func TestReplicationLogEntries(t *testing.T) {
+	l, _ := testLog(t, 10)
+	entries, err := l.Entries(0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(mustJSON(t, entries[0]))) + 1
+
+	tests := []struct {
+		name     string
+		after    uint64
+		limit    int
+		maxBytes int64
+		first    uint64
+		count    int
+	}{
+		{"all", 0, 0, 0, 1, 10},
+		{"from the middle", 4, 0, 0, 5, 6},
+		{"limit", 2, 3, 0, 3, 3},
+		{"up to date", 10, 0, 0, 0, 0},
+		{"byte limit", 0, 0, 3*size + size/2, 1, 3},
+		{"byte limit below one entry", 5, 0, 1, 6, 1},
+		{"limit before byte limit", 0, 2, 5 * size, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := l.Entries(tt.after, tt.limit, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.count {
+				t.Fatalf("got %d entries, want %d", len(entries), tt.count)
+			}
+			for i, entry := range entries {
+				if entry.LSN != tt.first+uint64(i) {
+					t.Errorf("entry %d has LSN %d, want %d", i, entry.LSN, tt.first+uint64(i))
+				}
+			}
+		})
+	}
+
+	for lsn, want := range map[uint64]uint64{1: 1, 4: 2, 10: 5} {
+		if term, err := l.TermAt(lsn); err != nil || term != want {
+			t.Errorf("TermAt(%d) = %d, %v, want %d", lsn, term, err, want)
+		}
+	}
+	if _, err := l.TermAt(11); err == nil || !strings.Contains(err.Error(), "not in the log") {
+		t.Errorf("TermAt(11): err = %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// rateWindow is the period a slave's apply rate is averaged over.
+const rateWindow = time.Minute
+
+// ackSample is a slave's acknowledged LSN at one point in time.
+type ackSample struct {
+	at  time.Time
+	lsn uint64
+}
+
+// recordSampleLocked keeps at most one sample per second for the last
+// rateWindow. s.mu must be held.
+func (s *slaveState) recordSampleLocked(now time.Time, lsn uint64) {
+	if n := len(s.samples); n > 0 && lsn < s.samples[n-1].lsn {
+		// The slave moved back, for example after loading a snapshot.
+		s.samples = nil
+	}
+	if n := len(s.samples); n > 0 && now.Sub(s.samples[n-1].at) < time.Second {
+		return
+	}
+	s.samples = append(s.samples, ackSample{at: now, lsn: lsn})
+	drop := 0
+	for drop < len(s.samples)-1 && now.Sub(s.samples[drop].at) > rateWindow {
+		drop++
+	}
+	s.samples = s.samples[drop:]
+}
+
+// applyRate returns the entries per second the slave applied over the last
+// rateWindow. A slave that applied nothing in that time has a rate of 0.
+func (s *slaveState) applyRate(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest *ackSample
+	for i := range s.samples {
+		if now.Sub(s.samples[i].at) <= rateWindow {
+			oldest = &s.samples[i]
+			break
+		}
+	}
+	if oldest == nil || s.ackedLSN <= oldest.lsn {
+		return 0
+	}
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	return float64(s.ackedLSN-oldest.lsn) / elapsed
+}
+
+// SlaveReplicationStatus is the master's view of how current one slave is.
+type SlaveReplicationStatus struct {
+	Address    string `json:"address"`
+	Online     bool   `json:"online"`
+	Health     string `json:"health"`
+	AppliedLSN uint64 `json:"applied_lsn"`
+	LagEntries uint64 `json:"lag_entries"`
+	// LagSeconds is how long ago the master committed the oldest entry the
+	// slave has not applied, 0 when it is current and -1 when unknown.
+	LagSeconds float64   `json:"lag_seconds"`
+	ApplyRate  float64   `json:"apply_rate"` // entries per second over the last minute
+	LastAck    time.Time `json:"last_ack,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+
+	// Filter is what the slave replicates, when it does not take everything.
+	Filter *ReplicationFilter `json:"filter,omitempty"`
+}
+
+// ReplicationStatus is served on /replication/status.
+type ReplicationStatus struct {
+	Master string                   `json:"master"`
+	Term   uint64                   `json:"term"`
+	LSN    uint64                   `json:"lsn"`
+	Slaves []SlaveReplicationStatus `json:"slaves"`
+}
+
+func replicationStatus() ReplicationStatus {
+	now := time.Now()
+	status := ReplicationStatus{
+		Master: cfg.Advertise,
+		Term:   currentTerm(),
+		LSN:    replicationLog.LastLSN(),
+		Slaves: []SlaveReplicationStatus{},
+	}
+	rangeSlaves(func(s *slaveState) {
+		status.Slaves = append(status.Slaves, s.replicationStatus(status.LSN, now))
+	})
+	sort.Slice(status.Slaves, func(i, j int) bool { return status.Slaves[i].Address < status.Slaves[j].Address })
+	return status
+}
+
+func (s *slaveState) replicationStatus(lastLSN uint64, now time.Time) SlaveReplicationStatus {
+	s.mu.Lock()
+	st := SlaveReplicationStatus{
+		Address:    s.addr,
+		Online:     s.online,
+		AppliedLSN: s.ackedLSN,
+		LastAck:    s.lastAck,
+		LastError:  s.lastErr,
+	}
+	if s.replFilter.active() {
+		filter := s.replFilter
+		st.Filter = &filter
+	}
+	s.mu.Unlock()
+	st.Health = s.health.status().State
+	st.ApplyRate = s.applyRate(now)
+
+	if st.AppliedLSN >= lastLSN {
+		return st
+	}
+	st.LagEntries = lastLSN - st.AppliedLSN
+	st.LagSeconds = -1
+	if committed, ok := replicationLog.TimeAt(st.AppliedLSN + 1); ok {
+		st.LagSeconds = now.Sub(committed).Seconds()
+	}
+	return st
+}
+
+func replicationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, replicationStatus())
+}
+
+// printReplicationStatus is the master dashboard's replication report.
+func printReplicationStatus() {
+	status := replicationStatus()
+	fmt.Println("\nReplication Status:")
+	fmt.Println("------------------")
+	fmt.Printf("Term: %d\n", status.Term)
+	fmt.Printf("Master LSN: %d\n", status.LSN)
+	topo := currentTopology()
+	fmt.Printf("Topology version %d:", topo.Version)
+	for _, m := range topo.Nodes {
+		fmt.Printf(" %s", m.Address)
+		if m.NonVoting {
+			fmt.Print(" (non-voting)")
+		}
+	}
+	fmt.Println()
+	if len(status.Slaves) == 0 {
+		fmt.Println("No slaves registered")
+		return
+	}
+	for _, s := range status.Slaves {
+		online := "Offline"
+		if s.Online {
+			online = "Online"
+		}
+		lag := "unknown"
+		if s.LagSeconds >= 0 {
+			lag = fmt.Sprintf("%.1fs", s.LagSeconds)
+		}
+		fmt.Printf("Slave %s: %s, %s\n", s.Address, online, s.Health)
+		fmt.Printf("  applied LSN %d, %d entries (%s) behind, %.1f entries/s\n", s.AppliedLSN, s.LagEntries, lag, s.ApplyRate)
+		if s.Filter != nil {
+			fmt.Printf("  replicates: %s\n", s.Filter)
+		}
+		if s.LastError != "" {
+			fmt.Printf("  last error: %s\n", s.LastError)
+		}
+	}
+}
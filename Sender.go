@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	senderMinBackoff = 200 * time.Millisecond
+	senderMaxBackoff = 30 * time.Second
+)
+
+// slaveState is the master's view of one registered slave. Each slave has a
+// single sender goroutine that pushes log entries in LSN order and only moves
+// on once the slave has acknowledged them.
+type slaveState struct {
+	addr string
+	wake chan struct{} // nudges the sender, for example when the slave re-registers
+
+	mu       sync.Mutex
+	online   bool
+	ackedLSN uint64 // highest LSN the slave confirmed as applied
+	lastAck  time.Time
+	lastErr  string
+	samples  []ackSample // recent acknowledgements, for the apply rate
+
+	health peerHealth // the failure detector's view of the slave
+
+	replFilter ReplicationFilter // what the slave asked to receive
+}
+
+// getSlave returns the state for addr, creating it and starting its sender on
+// first use.
+func getSlave(addr string) *slaveState {
+	if v, ok := slaveConnections.Load(addr); ok {
+		return v.(*slaveState)
+	}
+	s := &slaveState{addr: addr, wake: make(chan struct{}, 1)}
+	if v, loaded := slaveConnections.LoadOrStore(addr, s); loaded {
+		return v.(*slaveState)
+	}
+	go s.run()
+	return s
+}
+
+// rangeSlaves calls fn for every registered slave.
+func rangeSlaves(fn func(s *slaveState)) {
+	slaveConnections.Range(func(key, value interface{}) bool {
+		fn(value.(*slaveState))
+		return true
+	})
+}
+
+func (s *slaveState) isOnline() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.online
+}
+
+func (s *slaveState) setOnline(online bool) {
+	s.mu.Lock()
+	s.online = online
+	s.mu.Unlock()
+	if online {
+		s.nudge()
+	}
+	// A sync write waits for every online slave, so that set changing may
+	// complete or fail a pending write.
+	notifyAck()
+}
+
+func (s *slaveState) acked() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ackedLSN
+}
+
+// setAcked records the position the slave reported. The slave is the
+// authority on what it has applied, so the value may also move backwards.
+func (s *slaveState) setAcked(lsn uint64) {
+	s.mu.Lock()
+	s.ackedLSN = lsn
+	s.lastAck = time.Now()
+	s.lastErr = ""
+	s.recordSampleLocked(s.lastAck, lsn)
+	s.mu.Unlock()
+	notifyAck()
+}
+
+// setPosition records the LSN a slave reported when it registered.
+func (s *slaveState) setPosition(lsn uint64) {
+	s.mu.Lock()
+	s.ackedLSN = lsn
+	s.mu.Unlock()
+}
+
+func (s *slaveState) fail(err error) {
+	s.mu.Lock()
+	s.lastErr = err.Error()
+	s.mu.Unlock()
+}
+
+func (s *slaveState) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run is the slave's sender. It never has more than one entry in flight, so
+// the slave sees entries strictly in LSN order, and it retries a failed entry
+// with exponential backoff until the slave acknowledges it.
+func (s *slaveState) run() {
+	backoff := senderMinBackoff
+	retry := func(err error) {
+		s.fail(err)
+		countSendFailure(s.addr)
+		slog.Warn("Replication failed, retrying", "slave", s.addr, "retry_in", backoff.String(), "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-s.wake:
+		}
+		backoff *= 2
+		if backoff > senderMaxBackoff {
+			backoff = senderMaxBackoff
+		}
+	}
+
+	for {
+		if !isCurrentMaster() {
+			// This node stepped down; a new master runs its own senders.
+			slaveConnections.Delete(s.addr)
+			return
+		}
+		if v, ok := slaveConnections.Load(s.addr); !ok || v.(*slaveState) != s {
+			// The slave was removed from the cluster.
+			return
+		}
+
+		acked := s.acked()
+		entries, err := replicationLog.Entries(acked, 100, entryBatchBytes)
+		if err == errLogCompacted {
+			// The slave notices this when it registers and loads a snapshot.
+			s.fail(fmt.Errorf("slave at LSN %d is behind the start of the log and needs a snapshot", acked))
+			<-s.wake
+			continue
+		}
+		if err != nil {
+			retry(err)
+			continue
+		}
+		if len(entries) == 0 {
+			select {
+			case <-replicationLog.Wait(acked):
+			case <-s.wake:
+			}
+			continue
+		}
+
+		prevTerm, prevKnown := termOf(acked)
+		filter := s.filter()
+		for _, entry := range entries {
+			entry.Task = filter.filter(entry.Task)
+			applied, err := pushEntry(s.addr, entry, prevTerm, prevKnown)
+			if err != nil {
+				if applied > 0 {
+					s.setAcked(applied)
+				}
+				retry(err)
+				break
+			}
+			backoff = senderMinBackoff
+			s.setAcked(applied)
+			if applied != entry.LSN {
+				// The slave is at a different position than we thought;
+				// continue from the position it reported.
+				break
+			}
+			prevTerm, prevKnown = entry.Term, true
+		}
+	}
+}
+
+// pushTimeout is how long a slave gets to receive and apply an entry of size
+// bytes: ten seconds, plus a second for every 256 KiB.
+func pushTimeout(size int) time.Duration {
+	return 10*time.Second + time.Duration(size/(256<<10))*time.Second
+}
+
+// pushEntry sends one entry to a slave, stamped with this master's term and,
+// when known, the term of the entry before it. It returns the LSN the slave
+// reports as applied. Any response other than 200 OK is a failure, even if it
+// carries a position.
+func pushEntry(addr string, entry LogEntry, prevTerm uint64, prevKnown bool) (uint64, error) {
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	client := nodeClient(pushTimeout(len(jsonData)))
+	req, err := http.NewRequest(http.MethodPost, addr+"/replicate/apply", bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(termHeader, strconv.FormatUint(currentTerm(), 10))
+	req.Header.Set(masterHeader, cfg.Advertise)
+	if entry.Task.RequestID != "" {
+		req.Header.Set(requestIDHeader, entry.Task.RequestID)
+	}
+	req.Header.Set(lastLSNHeader, strconv.FormatUint(replicationLog.LastLSN(), 10))
+	if prevKnown {
+		req.Header.Set(prevTermHeader, strconv.FormatUint(prevTerm, 10))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var ack struct {
+		LSN    uint64 `json:"lsn"`
+		Error  string `json:"error"`
+		Code   string `json:"code"`
+		Term   uint64 `json:"term"`
+		Master string `json:"master"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&ack)
+	if ack.Code == "stale_term" && ack.Term > currentTerm() {
+		// The slave follows a newer master: this one has been replaced.
+		if ack.Master == "" || !followLeader(ack.Term, ack.Master) {
+			observeTerm(ack.Term)
+		}
+		return ack.LSN, fmt.Errorf("slave is at term %d: %s", ack.Term, ack.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if ack.Error != "" {
+			return ack.LSN, fmt.Errorf("slave rejected LSN %d: %s", entry.LSN, ack.Error)
+		}
+		return ack.LSN, fmt.Errorf("slave rejected LSN %d: %s", entry.LSN, resp.Status)
+	}
+	if decodeErr != nil {
+		return 0, fmt.Errorf("invalid acknowledgement for LSN %d: %w", entry.LSN, decodeErr)
+	}
+	return ack.LSN, nil
+}
+
+// registerSlave adds a slave, or marks a known one online again, and records
+// the LSN the slave says it has applied so its sender resumes from there.
+func registerSlave(w http.ResponseWriter, r *http.Request) {
+	slaveAddr := r.URL.Query().Get("address")
+	if slaveAddr == "" {
+		http.Error(w, "Parameter address is required", http.StatusBadRequest)
+		return
+	}
+
+	// Slaves that start with this node as their master join the cluster when
+	// they register, unless they were removed from it. Without -peers they
+	// join as non-voting members: any process that can reach this endpoint
+	// would otherwise change the quorum. An admin adds voters explicitly.
+	if !isMember(slaveAddr) {
+		if topo := currentTopology(); topo.wasRemoved(slaveAddr) {
+			writeJSON(w, http.StatusGone, map[string]interface{}{
+				"error":    slaveAddr + " has been removed from the cluster",
+				"code":     "removed",
+				"topology": topo,
+			})
+			return
+		}
+		if _, err := addMember(slaveAddr, len(cfg.Peers) == 0); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to add the slave to the cluster", err)
+			return
+		}
+	}
+
+	filter, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, "Parameter filter is invalid: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := getSlave(slaveAddr)
+	s.setFilter(filter)
+	diverged := false
+	if v := r.URL.Query().Get("lsn"); v != "" {
+		lsn, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Parameter lsn must be an LSN", http.StatusBadRequest)
+			return
+		}
+		s.setPosition(lsn)
+
+		// A slave whose last entry was written in another term than ours at
+		// the same LSN holds writes we never had, usually because it was the
+		// master before a failover. It has to reload a snapshot.
+		if v := r.URL.Query().Get("term"); v != "" {
+			slaveTerm, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "Parameter term must be a term", http.StatusBadRequest)
+				return
+			}
+			if term, ok := termOf(lsn); ok && term != slaveTerm {
+				diverged = true
+			}
+		}
+	}
+	s.health.reset(healthAlive)
+	s.setOnline(true)
+
+	topo := currentTopology()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "registered",
+		"lsn":      replicationLog.LastLSN(),
+		"base":     replicationLog.Base(),
+		"term":     currentTerm(),
+		"diverged": diverged,
+		"topology": topo,
+	})
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+func printSlaveDashboard() {
+	clearScreen()
+	fmt.Println("╔════════════════════════════════════════════════════════════╗")
+	fmt.Println("║                    SLAVE SERVER DASHBOARD                   ║")
+	fmt.Println("╠════════════════════════════════════════════════════════════╣")
+	fmt.Printf("║ Status: Running on %-40s║\n", cfg.Listen)
+	fmt.Println("║                                                            ║")
+	fmt.Println("║ Master Status:                                             ║")
+	master := currentMaster()
+	masterStatus := "❌ Offline"
+	health := masterHealth.status()
+	if health.State == healthAlive || health.State == healthSuspect {
+		masterStatus = "✅ Online"
+	}
+	fmt.Printf("║   - %s: %s (%s)\n", master, masterStatus, health.State)
+	fmt.Println("║                                                            ║")
+	fmt.Println("║ Role: Slave                                                ║")
+	fmt.Println("║                                                            ║")
+	fmt.Println("║ Available Commands:                                        ║")
+	fmt.Println("║   1. Show Replication Status                               ║")
+	fmt.Println("║   2. Show Databases                                        ║")
+	fmt.Println("║   3. Show Tables in Database                               ║")
+	fmt.Println("║   4. Show Records in Table                                 ║")
+	fmt.Println("║   5. Insert Record                                         ║")
+	fmt.Println("║   6. Update Record                                         ║")
+	fmt.Println("║   7. Delete Record                                         ║")
+	fmt.Println("║   8. Select Records                                        ║")
+	fmt.Println("║   9. Refresh Dashboard                                     ║")
+	fmt.Println("║   0. Exit                                                  ║")
+	fmt.Println("╚════════════════════════════════════════════════════════════╝")
+	fmt.Print("\nEnter command number: ")
+}
+
+// runSlaveDashboard runs the slave dashboard until the user exits, which it
+// reports by returning true, or until this node is promoted to master.
+func runSlaveDashboard() bool {
+	for {
+		if isCurrentMaster() {
+			return false
+		}
+		printSlaveDashboard()
+		choice := prompt("")
+
+		switch choice {
+		case "1":
+			fmt.Println("\nReplication Status:")
+			fmt.Println("------------------")
+			health := masterHealth.status()
+			fmt.Printf("Master health: %s (%d missed heartbeats, last seen %s)\n",
+				health.State, health.Missed, health.LastSeen.Format(time.RFC3339))
+			fmt.Printf("Master: %s (term %d)\n", currentMaster(), currentTerm())
+			fmt.Printf("Applied LSN: %d\n", replicationLog.LastLSN())
+		case "2":
+			rows, err := db.Query("SHOW DATABASES")
+			if err != nil {
+				fmt.Println("Error showing databases:", err)
+			} else {
+				fmt.Println("\nDatabases:")
+				fmt.Println("----------")
+				for rows.Next() {
+					var dbname string
+					if err := rows.Scan(&dbname); err != nil {
+						fmt.Println("Error scanning database:", err)
+						continue
+					}
+					fmt.Println("- " + dbname)
+				}
+				rows.Close()
+			}
+		case "3":
+			dbname := prompt("Enter database name: ")
+			if !validIdent(dbname) {
+				fmt.Println("Invalid database name")
+				break
+			}
+
+			rows, err := db.Query("SHOW TABLES FROM " + quoteIdent(dbname))
+			if err != nil {
+				fmt.Println("Error showing tables:", err)
+			} else {
+				fmt.Printf("\nTables in %s:\n", dbname)
+				fmt.Println("----------------")
+				for rows.Next() {
+					var table string
+					if err := rows.Scan(&table); err != nil {
+						fmt.Println("Error scanning table:", err)
+						continue
+					}
+					fmt.Println("- " + table)
+				}
+				rows.Close()
+			}
+		case "4":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+
+			query, args, err := buildSelect(SelectQuery{DBName: dbname, Table: table})
+			if err != nil {
+				fmt.Println("Error selecting records:", err)
+				break
+			}
+			printQuery(query, args...)
+		case "5":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			values, err := parseAssignments(prompt("Enter values (e.g., id=1, name='John'): "))
+			if err != nil {
+				fmt.Println("Error reading values:", err)
+				break
+			}
+
+			// Send request to master
+			if err := forwardWrite("/insert", ReplicationTask{DBName: dbname, Table: table, Values: values}); err != nil {
+				fmt.Println("Error inserting record:", err)
+			} else {
+				fmt.Println("Record inserted successfully")
+			}
+		case "6":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			set, err := parseAssignments(prompt("Enter new values (e.g., name='John'): "))
+			if err != nil {
+				fmt.Println("Error reading values:", err)
+				break
+			}
+			where, err := parseConditions(prompt("Enter conditions (e.g., id=1): "))
+			if err != nil {
+				fmt.Println("Error reading conditions:", err)
+				break
+			}
+
+			// Send request to master
+			if err := forwardWrite("/update", ReplicationTask{DBName: dbname, Table: table, Set: set, Where: where}); err != nil {
+				fmt.Println("Error updating record:", err)
+			} else {
+				fmt.Println("Record updated successfully")
+			}
+		case "7":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			where, err := parseConditions(prompt("Enter conditions (e.g., id=1): "))
+			if err != nil {
+				fmt.Println("Error reading conditions:", err)
+				break
+			}
+
+			// Send request to master
+			if err := forwardWrite("/delete", ReplicationTask{DBName: dbname, Table: table, Where: where}); err != nil {
+				fmt.Println("Error deleting record:", err)
+			} else {
+				fmt.Println("Record deleted successfully")
+			}
+		case "8":
+			dbname := prompt("Enter database name: ")
+			table := prompt("Enter table name: ")
+			q := SelectQuery{DBName: dbname, Table: table}
+			if cond := prompt("Enter conditions (e.g., id=1, leave empty for all): "); cond != "" {
+				where, err := parseConditions(cond)
+				if err != nil {
+					fmt.Println("Error reading conditions:", err)
+					break
+				}
+				q.Where = where
+			}
+
+			// Served from this slave's own copy of the data
+			query, args, err := buildSelect(q)
+			if err != nil {
+				fmt.Println("Error selecting records:", err)
+				break
+			}
+			if lag, known := staleness(); known {
+				fmt.Printf("Reading locally at LSN %d (at most %v behind the master)\n", replicationLog.LastLSN(), lag.Round(time.Millisecond))
+			} else {
+				fmt.Printf("Reading locally at LSN %d (staleness unknown)\n", replicationLog.LastLSN())
+			}
+			printQuery(query, args...)
+		case "9":
+			continue
+		case "0":
+			fmt.Println("Exiting...")
+			return true
+		default:
+			prompt("Invalid choice. Press Enter to continue...")
+		}
+
+		prompt("\nPress Enter to continue...")
+	}
+}
+
+// forwardWrite sends a write entered on the slave dashboard to the master.
+func forwardWrite(path string, task ReplicationTask) error {
+	id := newRequestID()
+	slog.Info("Forwarding write to master", "request_id", id, "path", path, "master", currentMaster())
+	resp, err := postJSON(currentMaster()+path, id, task)
+	if err != nil {
+		return fmt.Errorf("sending request to master: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func defineBasicRoutes() {
+	http.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("pong"))
+	})
+
+	internalRoute("/replication/checksum", serveChecksums)
+
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, nodeStatus())
+	})
+
+	// Define replication routes
+	internalRoute("/replicate/apply", replicateApply)
+}
+
+// followMaster keeps this slave registered with the master and brings it up
+// to date whenever the master has entries it has not applied yet, for
+// example after the slave or the network was down.
+func followMaster() {
+	registered := false
+	// refusedBy is the master whose snapshot failed for good; it is not
+	// tried again until another master is elected or the node restarts.
+	refusedBy := ""
+	for {
+		if isCurrentMaster() || currentMaster() == "" || !isMember(cfg.Advertise) {
+			registered = false
+			time.Sleep(time.Second)
+			continue
+		}
+
+		master, err := registerWithMaster()
+		if err != nil {
+			if registered {
+				slog.Warn("Lost contact with master", "master", currentMaster(), "error", err)
+			}
+			registered = false
+			time.Sleep(time.Second)
+			continue
+		}
+		if !registered {
+			slog.Info("Registered with master", "master", currentMaster())
+			registered = true
+		}
+
+		if needsSnapshot(master) {
+			if refusedBy == currentMaster() {
+				time.Sleep(5 * time.Second)
+				continue
+			}
+			if err := loadSnapshot(); err != nil {
+				if permanentSnapshotError(err) {
+					refusedBy = currentMaster()
+					slog.Error("Snapshot cannot be loaded, not retrying until the node restarts or the master changes", "master", refusedBy, "error", err)
+				} else {
+					slog.Error("Snapshot bootstrap failed", "error", err)
+				}
+				time.Sleep(5 * time.Second)
+				continue
+			}
+		}
+		if master.LSN > replicationLog.LastLSN() {
+			if err := catchUp(); err != nil {
+				slog.Warn("Catch-up failed", "error", err)
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// masterInfo is the master's view of its replication log, as returned when a
+// slave registers.
+type masterInfo struct {
+	LSN  uint64 `json:"lsn"`
+	Base uint64 `json:"base"`
+	Term uint64 `json:"term"`
+
+	// Diverged is set when this node's log holds writes the master does not
+	// have; the node must reload a snapshot.
+	Diverged bool `json:"diverged"`
+
+	Topology *Topology `json:"topology"`
+}
+
+// registerWithMaster announces this slave to the master and returns the state
+// of the master's replication log. A node that is no longer the master answers
+// with the master it knows about, which the slave then follows.
+func registerWithMaster() (masterInfo, error) {
+	master := currentMaster()
+	sent := time.Now()
+	registerURL := fmt.Sprintf("%s/register-slave?address=%s&lsn=%d&term=%d",
+		master, url.QueryEscape(cfg.Advertise), replicationLog.LastLSN(), replicationLog.LastTerm())
+	if cfg.ReplicationFilter.active() {
+		filter, err := json.Marshal(cfg.ReplicationFilter)
+		if err != nil {
+			return masterInfo{}, err
+		}
+		registerURL += "&filter=" + url.QueryEscape(string(filter))
+	}
+	resp, err := nodeGet(registerURL)
+	if err != nil {
+		return masterInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var notMaster struct {
+			Code     string    `json:"code"`
+			Master   string    `json:"master"`
+			Term     uint64    `json:"term"`
+			Topology *Topology `json:"topology"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&notMaster) == nil {
+			switch {
+			case notMaster.Code == "removed":
+				adoptTopology(notMaster.Topology)
+			case notMaster.Code == "not_master" && notMaster.Master != master && notMaster.Master != cfg.Advertise:
+				followLeader(notMaster.Term, notMaster.Master)
+			}
+		}
+		return masterInfo{}, fmt.Errorf("master returned %s", resp.Status)
+	}
+
+	var info masterInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return masterInfo{}, err
+	}
+	if !followLeader(info.Term, master) {
+		return masterInfo{}, fmt.Errorf("%s is master of term %d, but this node already knows term %d", master, info.Term, currentTerm())
+	}
+	adoptTopology(info.Topology)
+	noteMasterPosition(info.LSN, sent)
+	return info, nil
+}
+
+// applyMu serializes everything that applies log entries on this slave, so
+// entries are applied exactly in LSN order.
+var applyMu sync.Mutex
+
+// catchUp fetches and applies every entry the master has after our last
+// applied LSN.
+func catchUp() error {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+	return catchUpLocked()
+}
+
+func catchUpLocked() error {
+	const batchSize = 500
+	for {
+		after := replicationLog.LastLSN()
+		sent := time.Now()
+		resp, err := nodeGet(fmt.Sprintf("%s/replication/log?after=%d&limit=%d", currentMaster(), after, batchSize))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("master returned %s", resp.Status)
+		}
+		if err := checkPrevTerm(after, resp.Header.Get(prevTermHeader)); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		masterLSN, lsnErr := strconv.ParseUint(resp.Header.Get(lastLSNHeader), 10, 64)
+		if lsnErr == nil {
+			noteMasterPosition(masterLSN, sent)
+		}
+
+		count := 0
+		decoder := json.NewDecoder(resp.Body)
+		decoder.UseNumber()
+		for decoder.More() {
+			var entry LogEntry
+			if err := decoder.Decode(&entry); err != nil {
+				resp.Body.Close()
+				return fmt.Errorf("decoding log entry: %w", err)
+			}
+			if err := applyEntryLocked(entry); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			count++
+		}
+		resp.Body.Close()
+
+		if count > 0 {
+			slog.Info("Caught up", "from_lsn", after, "lsn", replicationLog.LastLSN())
+		}
+		// The master ends a batch early once it holds entryBatchBytes, so
+		// a short batch only means the end of the log if it says so.
+		done := count < batchSize
+		if lsnErr == nil {
+			done = count == 0 || replicationLog.LastLSN() >= masterLSN
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// applyEntry applies an entry pushed by the master. Entries that were already
+// applied are ignored; if earlier entries are missing they are fetched from
+// the master first. prevTerm is the master's term for the entry before this
+// one, or "" if the master did not send it. It returns the LSN the slave has
+// applied afterwards.
+func applyEntry(entry LogEntry, prevTerm string) (uint64, error) {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	if entry.LSN > replicationLog.LastLSN()+1 {
+		if err := catchUpLocked(); err != nil {
+			return replicationLog.LastLSN(), fmt.Errorf("catching up before LSN %d: %w", entry.LSN, err)
+		}
+	}
+	if entry.LSN <= replicationLog.LastLSN() {
+		return replicationLog.LastLSN(), nil
+	}
+	if err := checkPrevTerm(entry.LSN-1, prevTerm); err != nil {
+		return replicationLog.LastLSN(), err
+	}
+	if err := applyEntryLocked(entry); err != nil {
+		return replicationLog.LastLSN(), err
+	}
+	return entry.LSN, nil
+}
+
+// checkPrevTerm compares the term of the local entry at lsn with the master's.
+// If they differ this node applied writes the master never had, typically as
+// a master that was replaced while it was cut off. Those writes cannot be
+// undone entry by entry, so the node marks itself for a snapshot reload.
+func checkPrevTerm(lsn uint64, masterTerm string) error {
+	if masterTerm == "" {
+		return nil
+	}
+	expected, err := strconv.ParseUint(masterTerm, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header %q", prevTermHeader, masterTerm)
+	}
+	local, ok := termOf(lsn)
+	if !ok || local == expected {
+		return nil
+	}
+	return diverged(lsn, local, expected)
+}
+
+// diverged marks this node for a snapshot reload because its entry at lsn,
+// of term local, is not the master's.
+func diverged(lsn, local, masterTerm uint64) error {
+	if err := os.WriteFile(snapshotPendingPath(), nil, 0644); err != nil {
+		return err
+	}
+	slog.Warn("Local log diverged from the master; reloading a snapshot", "lsn", lsn, "term", local, "master_term", masterTerm)
+	return fmt.Errorf("log diverged from the master at LSN %d", lsn)
+}
+
+func applyEntryLocked(entry LogEntry) error {
+	if entry.LSN != replicationLog.LastLSN()+1 {
+		return fmt.Errorf("out of order log entry: got LSN %d after %d", entry.LSN, replicationLog.LastLSN())
+	}
+	// The master already leaves out what our filter excludes, but a master
+	// that has not seen the filter yet, or catch-up from its log, may not.
+	entry.Task = cfg.ReplicationFilter.filter(entry.Task)
+	// The entry is committed to MySQL, together with its LSN, before it is
+	// logged. If the node stopped in between, MySQL already holds it.
+	applied, err := takeUnlogged(entry)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		if err := applyLogged(entry); err != nil {
+			return fmt.Errorf("applying LSN %d: %w", entry.LSN, err)
+		}
+	}
+	if err := replicationLog.AppendEntry(entry); err != nil {
+		return err
+	}
+	if entry.Task.Operation == "skip" {
+		slog.Debug("Skipped filtered write", taskAttrs(entry.LSN, entry.Task)...)
+	} else {
+		slog.Info("Applied replicated write", taskAttrs(entry.LSN, entry.Task)...)
+	}
+	return nil
+}
+
+func replicateApply(w http.ResponseWriter, r *http.Request) {
+	var entry LogEntry
+	if err := decodeJSON(r.Body, &entry); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if isCurrentMaster() {
+		// A master only takes writes from its own clients.
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error": "this node is the master",
+			"code":  "not_slave",
+			"lsn":   replicationLog.LastLSN(),
+		})
+		return
+	}
+	if !followLeader(requestTerm(r), r.Header.Get(masterHeader)) {
+		// Fencing: entries from a master of an older term are refused.
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":  "replication from a stale term",
+			"code":   "stale_term",
+			"term":   currentTerm(),
+			"master": currentMaster(),
+			"lsn":    replicationLog.LastLSN(),
+		})
+		return
+	}
+
+	if lsn, err := strconv.ParseUint(r.Header.Get(lastLSNHeader), 10, 64); err == nil {
+		noteMasterPosition(lsn, time.Now())
+	}
+	applied, err := applyEntry(entry, r.Header.Get(prevTermHeader))
+	if err != nil {
+		slog.Error("Replication failed", append(taskAttrs(entry.LSN, entry.Task), "error", err)...)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": err.Error(),
+			"lsn":   applied,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Entry applied successfully",
+		"lsn":     applied,
+	})
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotRecord is one line of a snapshot stream. A stream starts with a
+// "begin" record carrying the LSN the snapshot was taken at, followed by
+// "database", "table" and "rows" records, and ends with an "end" record. A
+// stream without the end record is incomplete and must not be used. A table
+// record carries the master's CREATE TABLE statement, so that defaults,
+// indexes, foreign keys and character sets survive, and its columns.
+type SnapshotRecord struct {
+	Type       string          `json:"type"`
+	LSN        uint64          `json:"lsn,omitempty"`
+	ServerUUID string          `json:"server_uuid,omitempty"`
+	DBName     string          `json:"dbname,omitempty"`
+	Table      string          `json:"table,omitempty"`
+	Schema     []ColumnDef     `json:"schema,omitempty"`
+	CreateSQL  string          `json:"create_sql,omitempty"`
+	Columns    []string        `json:"columns,omitempty"`
+	Rows       [][]interface{} `json:"rows,omitempty"`
+}
+
+const snapshotBatchSize = 500
+
+// systemDatabases are never part of a snapshot.
+var systemDatabases = map[string]bool{
+	"information_schema": true,
+	"mysql":              true,
+	"performance_schema": true,
+	"sys":                true,
+	stateDatabase:        true,
+}
+
+// serveSnapshot streams a consistent copy of every managed database. Writes are
+// paused only while the snapshot transaction starts and the schema is read;
+// the rows are then read from InnoDB's consistent view.
+func serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		http.Error(w, "Failed to get connection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		http.Error(w, "Failed to start snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeMu.Lock()
+	_, err = conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT")
+	lsn := replicationLog.LastLSN()
+	var schema []SnapshotRecord
+	if err == nil {
+		schema, err = snapshotSchema(ctx, conn)
+	}
+	writeMu.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to start snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.ExecContext(context.Background(), "ROLLBACK")
+
+	var serverUUID string
+	conn.QueryRowContext(ctx, "SELECT @@server_uuid").Scan(&serverUUID)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	encoder.Encode(SnapshotRecord{Type: "begin", LSN: lsn, ServerUUID: serverUUID})
+	for _, record := range schema {
+		if err := encoder.Encode(record); err != nil {
+			return
+		}
+		if record.Type != "table" {
+			continue
+		}
+		if err := streamTableRows(ctx, conn, encoder, record); err != nil {
+			slog.Error("Snapshot failed", "db", record.DBName, "table", record.Table, "error", err)
+			return
+		}
+	}
+	encoder.Encode(SnapshotRecord{Type: "end", LSN: lsn})
+	slog.Info("Served snapshot", "lsn", lsn, "remote", r.RemoteAddr, "request_id", requestID(r.Context()))
+}
+
+// snapshotSchema lists the managed databases with their tables, column
+// definitions and CREATE TABLE statements. Databases and tables whose names
+// the API cannot express are left out.
+func snapshotSchema(ctx context.Context, conn *sql.Conn) ([]SnapshotRecord, error) {
+	var dbnames []string
+	rows, err := conn.QueryContext(ctx, "SHOW DATABASES")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if systemDatabases[strings.ToLower(name)] {
+			continue
+		}
+		if !validIdent(name) {
+			slog.Warn("Snapshot skips database: name is not a valid identifier", "db", name)
+			continue
+		}
+		dbnames = append(dbnames, name)
+	}
+	rows.Close()
+
+	var records []SnapshotRecord
+	for _, dbname := range dbnames {
+		records = append(records, SnapshotRecord{Type: "database", DBName: dbname})
+
+		var tables []string
+		rows, err := conn.QueryContext(ctx, "SHOW FULL TABLES FROM "+quoteIdent(dbname)+" WHERE Table_type = 'BASE TABLE'")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name, kind string
+			if err := rows.Scan(&name, &kind); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !validIdent(name) {
+				slog.Warn("Snapshot skips table: name is not a valid identifier", "db", dbname, "table", name)
+				continue
+			}
+			tables = append(tables, name)
+		}
+		rows.Close()
+
+		for _, table := range tables {
+			schema, err := tableSchema(ctx, conn, dbname, table)
+			if err != nil {
+				return nil, err
+			}
+			var name, createSQL string
+			if err := conn.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteIdent(dbname)+"."+quoteIdent(table)).Scan(&name, &createSQL); err != nil {
+				return nil, err
+			}
+			records = append(records, SnapshotRecord{Type: "table", DBName: dbname, Table: table, Schema: schema, CreateSQL: createSQL})
+		}
+	}
+	return records, nil
+}
+
+// tableSchema reads the column definitions of a table in the form used by
+// the createtable operation. Consistency checks use them to find the primary
+// key.
+func tableSchema(ctx context.Context, conn *sql.Conn, dbname, table string) ([]ColumnDef, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, EXTRA
+		FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, dbname, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []ColumnDef
+	for rows.Next() {
+		var name, colType, nullable, key, extra string
+		if err := rows.Scan(&name, &colType, &nullable, &key, &extra); err != nil {
+			return nil, err
+		}
+		columns = append(columns, ColumnDef{
+			Name:          name,
+			Type:          colType,
+			NotNull:       nullable == "NO",
+			PrimaryKey:    key == "PRI",
+			AutoIncrement: strings.Contains(strings.ToLower(extra), "auto_increment"),
+			Unique:        key == "UNI",
+		})
+	}
+	return columns, rows.Err()
+}
+
+func streamTableRows(ctx context.Context, conn *sql.Conn, encoder *json.Encoder, table SnapshotRecord) error {
+	rows, err := conn.QueryContext(ctx, "SELECT * FROM "+quoteIdent(table.DBName)+"."+quoteIdent(table.Table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	binary, err := binaryColumns(rows)
+	if err != nil {
+		return err
+	}
+
+	batch := SnapshotRecord{Type: "rows", DBName: table.DBName, Table: table.Table, Columns: cols}
+	for rows.Next() {
+		columns := make([]interface{}, len(cols))
+		columnPointers := make([]interface{}, len(cols))
+		for i := range columns {
+			columnPointers[i] = &columns[i]
+		}
+		if err := rows.Scan(columnPointers...); err != nil {
+			return err
+		}
+		for i := range columns {
+			columns[i] = rowValue(columns[i], binary[i])
+		}
+
+		batch.Rows = append(batch.Rows, columns)
+		if len(batch.Rows) == snapshotBatchSize {
+			if err := encoder.Encode(batch); err != nil {
+				return err
+			}
+			batch.Rows = nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(batch.Rows) > 0 {
+		return encoder.Encode(batch)
+	}
+	return nil
+}
+
+var errSameServer = errors.New("refusing to load snapshot: this slave uses the same MySQL server as the master")
+
+// permanentSnapshotError reports whether loading a snapshot failed for a
+// reason that another attempt from the same master would not fix.
+func permanentSnapshotError(err error) bool {
+	var verr *validationError
+	return errors.As(err, &verr) || errors.Is(err, errSameServer)
+}
+
+// snapshotPendingPath marks a snapshot that was started but not finished. A
+// slave that finds it on startup has partially loaded data and must load a
+// new snapshot.
+func snapshotPendingPath() string {
+	return filepath.Join(cfg.DataDir, "snapshot.pending")
+}
+
+// needsSnapshot reports whether this slave must be bootstrapped from a
+// snapshot before it can follow the master's log.
+func needsSnapshot(master masterInfo) bool {
+	if _, err := os.Stat(snapshotPendingPath()); err == nil {
+		return true
+	}
+	if master.Diverged {
+		return true
+	}
+	local := replicationLog.LastLSN()
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, "replication.base")); os.IsNotExist(err) && local == 0 {
+		// Never bootstrapped: the master may hold data older than its log.
+		return true
+	}
+	if local < master.Base || local > master.LSN {
+		return true
+	}
+	return cfg.SnapshotThreshold > 0 && master.LSN-local > cfg.SnapshotThreshold
+}
+
+// loadSnapshot replaces the managed databases on this slave with a snapshot
+// from the master and restarts the local log at the snapshot's LSN. Databases
+// the master does not have are dropped.
+func loadSnapshot() error {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	resp, err := nodeGet(currentMaster() + "/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("master returned %s", resp.Status)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+
+	var begin SnapshotRecord
+	if err := decoder.Decode(&begin); err != nil || begin.Type != "begin" {
+		return fmt.Errorf("snapshot stream does not start with a begin record")
+	}
+	var serverUUID string
+	conn.QueryRowContext(ctx, "SELECT @@server_uuid").Scan(&serverUUID)
+	if serverUUID != "" && serverUUID == begin.ServerUUID {
+		return errSameServer
+	}
+
+	if err := os.WriteFile(snapshotPendingPath(), nil, 0644); err != nil {
+		return err
+	}
+	slog.Info("Loading snapshot from master", "lsn", begin.LSN)
+
+	inSnapshot := make(map[string]bool)
+	for {
+		var record SnapshotRecord
+		if err := decoder.Decode(&record); err != nil {
+			return fmt.Errorf("snapshot stream ended early: %w", err)
+		}
+		if record.Type == "database" {
+			inSnapshot[record.DBName] = true
+		}
+		if record.Type != "end" && !cfg.ReplicationFilter.allows(ReplicationTask{DBName: record.DBName, Table: record.Table}) {
+			// Filtered databases are left as they are on this node.
+			continue
+		}
+
+		switch record.Type {
+		case "database":
+			if _, err := applyTask(conn, ReplicationTask{Operation: "dropdb", DBName: record.DBName}); err != nil {
+				return err
+			}
+			if _, err := applyTask(conn, ReplicationTask{Operation: "createdb", DBName: record.DBName}); err != nil {
+				return err
+			}
+		case "table":
+			if err := createSnapshotTable(ctx, conn, record); err != nil {
+				return fmt.Errorf("creating %s.%s: %w", record.DBName, record.Table, err)
+			}
+		case "rows":
+			if err := insertSnapshotRows(ctx, conn, record); err != nil {
+				return fmt.Errorf("loading %s.%s: %w", record.DBName, record.Table, err)
+			}
+		case "end":
+			if record.LSN != begin.LSN {
+				return fmt.Errorf("snapshot end LSN %d does not match begin LSN %d", record.LSN, begin.LSN)
+			}
+			if err := dropMissingDatabases(ctx, conn, inSnapshot); err != nil {
+				return err
+			}
+			if err := replicationLog.Reset(begin.LSN); err != nil {
+				return err
+			}
+			if err := resetAppliedState(begin.LSN); err != nil {
+				return err
+			}
+			slog.Info("Snapshot loaded, following master", "lsn", begin.LSN)
+			return os.Remove(snapshotPendingPath())
+		default:
+			return fmt.Errorf("unknown snapshot record %q", record.Type)
+		}
+	}
+}
+
+// createSnapshotTable creates a table from the master's CREATE TABLE
+// statement. The statement names the table without its database, and foreign
+// keys to tables of the same database likewise, so it runs in that database;
+// the caller has turned off foreign key checks, as the referenced tables may
+// not exist yet.
+func createSnapshotTable(ctx context.Context, conn *sql.Conn, record SnapshotRecord) error {
+	if err := checkIdent("database", record.DBName); err != nil {
+		return err
+	}
+	if err := checkIdent("table", record.Table); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(record.CreateSQL, "CREATE TABLE "+quoteIdent(record.Table)+" ") {
+		return invalidf("snapshot has no CREATE TABLE statement for the table")
+	}
+	if _, err := conn.ExecContext(ctx, "USE "+quoteIdent(record.DBName)); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, record.CreateSQL)
+	return err
+}
+
+// dropMissingDatabases drops the managed databases on this slave that are not
+// in the snapshot, keeping the system databases. Databases the snapshot
+// lists but this slave's filter leaves out were not touched while loading and
+// are kept as well.
+func dropMissingDatabases(ctx context.Context, conn *sql.Conn, inSnapshot map[string]bool) error {
+	rows, err := conn.QueryContext(ctx, "SHOW DATABASES")
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if systemDatabases[strings.ToLower(name)] || inSnapshot[name] {
+			continue
+		}
+		if !validIdent(name) {
+			slog.Warn("Snapshot keeps database: name is not a valid identifier", "db", name)
+			continue
+		}
+		missing = append(missing, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range missing {
+		slog.Info("Dropping database the master does not have", "db", name)
+		if _, err := applyTask(conn, ReplicationTask{Operation: "dropdb", DBName: name}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertSnapshotRows(ctx context.Context, conn *sql.Conn, record SnapshotRecord) error {
+	if len(record.Rows) == 0 {
+		return nil
+	}
+	if err := checkIdent("database", record.DBName); err != nil {
+		return err
+	}
+	if err := checkIdent("table", record.Table); err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(record.Columns))
+	for i, col := range record.Columns {
+		if err := checkIdent("column", col); err != nil {
+			return err
+		}
+		quoted[i] = quoteIdent(col)
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(record.Columns)), ",") + ")"
+
+	values := make([]string, 0, len(record.Rows))
+	args := make([]interface{}, 0, len(record.Rows)*len(record.Columns))
+	for _, row := range record.Rows {
+		if len(row) != len(record.Columns) {
+			return fmt.Errorf("row has %d values for %d columns", len(row), len(record.Columns))
+		}
+		values = append(values, placeholders)
+		for i, v := range row {
+			v, err := checkValue(record.Columns[i], v)
+			if err != nil {
+				return err
+			}
+			args = append(args, v)
+		}
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES %s", quoteIdent(record.DBName), quoteIdent(record.Table),
+		strings.Join(quoted, ", "), strings.Join(values, ", "))
+	_, err := conn.ExecContext(ctx, query, args...)
+	return err
+}
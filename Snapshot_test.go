@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDropMissingDatabases(t *testing.T) {
+	var dropped []string
+	withFakeDB(t, func(query string, args []interface{}) (driver.Result, *fakeRows, error) {
+		if query == "SHOW DATABASES" {
+			var names [][]driver.Value
+			for _, name := range []string{"information_schema", "mysql", "_replication", "shop", "crm", "scratch", "my-db"} {
+				names = append(names, []driver.Value{name})
+			}
+			return nil, &fakeRows{columns: []string{"Database"}, values: names}, nil
+		}
+		if strings.HasPrefix(query, "DROP DATABASE") {
+			dropped = append(dropped, query)
+		}
+		return nil, nil, nil
+	})
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := dropMissingDatabases(ctx, conn, map[string]bool{"shop": true, "crm": true}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DROP DATABASE IF EXISTS `scratch`"}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped %q, want %q", dropped, want)
+	}
+}
+
+func TestCreateSnapshotTable(t *testing.T) {
+	var executed []string
+	withFakeDB(t, func(query string, args []interface{}) (driver.Result, *fakeRows, error) {
+		executed = append(executed, query)
+		return nil, nil, nil
+	})
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	createSQL := "CREATE TABLE `orders` (\n  `id` int NOT NULL AUTO_INCREMENT,\n  `item` varchar(64) NOT NULL DEFAULT 'pen',\n" +
+		"  PRIMARY KEY (`id`),\n  KEY `item` (`item`),\n  CONSTRAINT `fk` FOREIGN KEY (`item`) REFERENCES `items` (`name`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=8 DEFAULT CHARSET=latin1"
+	tests := []struct {
+		name       string
+		record     SnapshotRecord
+		ok         bool
+		statements []string
+	}{
+		{"create table", SnapshotRecord{DBName: "shop", Table: "orders", CreateSQL: createSQL}, true, []string{"USE `shop`", createSQL}},
+		{"other table", SnapshotRecord{DBName: "shop", Table: "users", CreateSQL: createSQL}, false, nil},
+		{"not a create table", SnapshotRecord{DBName: "shop", Table: "orders", CreateSQL: "DROP TABLE `orders`"}, false, nil},
+		{"no statement", SnapshotRecord{DBName: "shop", Table: "orders"}, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executed = nil
+			err := createSnapshotTable(ctx, conn, tt.record)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err = %v, want ok %v", err, tt.ok)
+			}
+			if !reflect.DeepEqual(executed, tt.statements) {
+				t.Errorf("executed %q, want %q", executed, tt.statements)
+			}
+		})
+	}
+}
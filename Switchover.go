@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultSwitchoverTimeout = 30 * time.Second
+
+// errSwitchoverTimeout is returned when the target did not catch up in time.
+// The master keeps its role and writes resume.
+var errSwitchoverTimeout = errors.New("target did not apply the whole log in time")
+
+// SwitchoverRequest asks the master to hand its role to Target.
+type SwitchoverRequest struct {
+	Target    string `json:"target"`
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+}
+
+// transferRequest asks a slave to take over as master. LSN is the last entry
+// the old master wrote; the slave must have applied it.
+type transferRequest struct {
+	From string `json:"from"`
+	LSN  uint64 `json:"lsn"`
+}
+
+// switchover hands the master role to target without losing writes. It
+// pauses writes, waits until target has applied everything up to the last
+// LSN, steps down and asks target to win the election for the next term.
+// If target does not catch up in time, nothing changes and writes resume.
+func switchover(target string, timeout time.Duration) error {
+	target = strings.TrimSuffix(target, "/")
+	if target == "" {
+		return invalidf("target is required")
+	}
+	if target == cfg.Advertise {
+		return invalidf("%s is already the master", target)
+	}
+	v, ok := slaveConnections.Load(target)
+	if !ok {
+		return invalidf("%s is not a registered slave", target)
+	}
+	s := v.(*slaveState)
+	if !s.isOnline() {
+		return invalidf("%s is offline", target)
+	}
+	if s.filter().active() {
+		return invalidf("%s only replicates %s and cannot become master", target, s.filter())
+	}
+	if !isVoter(target) {
+		return invalidf("%s is a non-voting member; add it with POST /cluster/nodes first", target)
+	}
+
+	// Holding writeMu pauses every write path. Writes that queue up behind
+	// it fail with errNotMaster once this node has stepped down.
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	if _, err := leadership(); err != nil {
+		return err
+	}
+
+	lsn := replicationLog.LastLSN()
+	slog.Info("Switchover: writes paused", "target", target, "lsn", lsn)
+	if err := waitForAck(s, lsn, timeout); err != nil {
+		slog.Warn("Switchover aborted, resuming writes", "target", target, "error", err)
+		return err
+	}
+
+	nodeMu.Lock()
+	if !isMaster {
+		nodeMu.Unlock()
+		return errNotMaster
+	}
+	isMaster = false
+	masterAddress = target
+	countStepDown()
+	rangeSlaves(func(s *slaveState) { s.nudge() })
+	resetElectionTimerLocked()
+	nodeMu.Unlock()
+	slog.Info("Switchover: stepped down", "target", target, "lsn", lsn)
+
+	if err := requestTransfer(target, lsn); err != nil {
+		return fmt.Errorf("stepped down, but %s did not take over (the cluster will elect a new master): %w", target, err)
+	}
+	slog.Info("Switchover complete", "target", target)
+	return nil
+}
+
+// waitForAck blocks until s has acknowledged lsn.
+func waitForAck(s *slaveState, lsn uint64, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		changed := ackChanged()
+		if s.acked() >= lsn {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return fmt.Errorf("%w: %s is at LSN %d of %d", errSwitchoverTimeout, s.addr, s.acked(), lsn)
+		}
+	}
+}
+
+// requestTransfer asks target to become master and waits for the result.
+func requestTransfer(target string, lsn uint64) error {
+	client := nodeClient(2 * electionTimeout())
+	data, err := json.Marshal(transferRequest{From: cfg.Advertise, LSN: lsn})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(target+"/election/transfer", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	return nil
+}
+
+// handleTransfer is the target's side of a switchover: it checks that it has
+// applied the old master's last write and runs an election for the next term.
+func handleTransfer(w http.ResponseWriter, r *http.Request) {
+	var req transferRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if isCurrentMaster() {
+		writeError(w, http.StatusConflict, "This node is already the master", nil)
+		return
+	}
+	if cfg.ReplicationFilter.active() {
+		writeError(w, http.StatusConflict, "This node has a replication filter and cannot become master", nil)
+		return
+	}
+	if applied := replicationLog.LastLSN(); applied < req.LSN {
+		writeError(w, http.StatusConflict, fmt.Sprintf("This node has applied LSN %d, not %d", applied, req.LSN), nil)
+		return
+	}
+
+	slog.Info("Taking over the master role", "from", req.From, "lsn", req.LSN)
+	if !runElection(true) {
+		writeError(w, http.StatusServiceUnavailable, "Election for the next term failed", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"master": cfg.Advertise, "term": currentTerm()})
+}
+
+func switchoverHandler(w http.ResponseWriter, r *http.Request) {
+	var req SwitchoverRequest
+	if err := decodeJSON(r.Body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	timeout := defaultSwitchoverTimeout
+	if req.TimeoutMS > 0 {
+		timeout = time.Duration(req.TimeoutMS) * time.Millisecond
+	}
+
+	err := switchover(req.Target, timeout)
+	switch {
+	case err == errNotMaster || err == errNoQuorum:
+		writeNotMaster(w, err)
+	case errors.Is(err, errSwitchoverTimeout):
+		writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": err.Error(), "code": "switchover_timeout"})
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "Switchover failed", err)
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message": "Switched over to " + req.Target,
+			"master":  currentMaster(),
+			"lsn":     replicationLog.LastLSN(),
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSwitchoverRejects(t *testing.T) {
+	const filtered = "http://node-e:8087"
+
+	tests := []struct {
+		name   string
+		target string
+		slave  bool // this node is not the master
+		err    string
+		is     error
+	}{
+		{"no target", "", false, "target is required", nil},
+		{"self", testSelf, false, "already the master", nil},
+		{"self with a slash", testSelf + "/", false, "already the master", nil},
+		{"unknown", "http://stranger:8083", false, "not a registered slave", nil},
+		{"offline", testOther, false, "is offline", nil},
+		{"filtered", filtered, false, "cannot become master", nil},
+		{"non-voter", testLearner, false, "non-voting member", nil},
+		{"not the master", testCandidate, true, "", errNotMaster},
+		{"target behind", testCandidate, false, "", errSwitchoverTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withElection(t, electionState{Term: 3, Master: testSelf})
+			savedQuorum := lastQuorum
+			t.Cleanup(func() { lastQuorum = savedQuorum })
+			isMaster, masterAddress, lastQuorum = !tt.slave, testSelf, time.Now()
+			withSlaves(t,
+				&slaveState{addr: testCandidate, online: true, ackedLSN: 3},
+				&slaveState{addr: testOther, online: false, ackedLSN: 4},
+				&slaveState{addr: testLearner, online: true, ackedLSN: 4},
+				&slaveState{addr: filtered, online: true, ackedLSN: 4, replFilter: ReplicationFilter{Include: []string{"shop"}}},
+			)
+
+			err := switchover(tt.target, 20*time.Millisecond)
+			if err == nil {
+				t.Fatal("switchover succeeded")
+			}
+			var verr *validationError
+			if tt.is != nil {
+				if !errors.Is(err, tt.is) {
+					t.Errorf("err = %v, want %v", err, tt.is)
+				}
+			} else if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("err = %v, want a validation error containing %q", err, tt.err)
+			}
+			if isMaster == tt.slave || masterAddress != testSelf {
+				t.Errorf("after a failed switchover master = %v, %q", isMaster, masterAddress)
+			}
+		})
+	}
+}
+
+func TestHandleTransferRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		master bool
+		filter ReplicationFilter
+		status int
+		reason string
+	}{
+		{"invalid body", "{", false, ReplicationFilter{}, http.StatusBadRequest, "Invalid request body"},
+		{"already master", `{"from":"http://node-c:8085","lsn":4}`, true, ReplicationFilter{}, http.StatusConflict, "already the master"},
+		{"filtered", `{"from":"http://node-c:8085","lsn":4}`, false, ReplicationFilter{Exclude: []string{"tmp"}}, http.StatusConflict, "replication filter"},
+		{"behind", `{"from":"http://node-c:8085","lsn":5}`, false, ReplicationFilter{}, http.StatusConflict, "applied LSN 4, not 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withElection(t, electionState{Term: 3, Master: testOther})
+			isMaster = tt.master
+			cfg.ReplicationFilter = tt.filter
+
+			w := httptest.NewRecorder()
+			handleTransfer(w, httptest.NewRequest(http.MethodPost, "/election/transfer", strings.NewReader(tt.body)))
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.reason) {
+				t.Errorf("got %d %s, want %d %q", w.Code, w.Body, tt.status, tt.reason)
+			}
+			if election.Term != 3 {
+				t.Errorf("term = %d, want 3: a rejected transfer must not start an election", election.Term)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig turns on HTTPS for the node's listener and for its requests to
+// other nodes. CAFile is the CA that signs the node certificates. With it
+// set, nodes present their certificate to each other (mutual TLS) and the
+// requests only other nodes may make need one; clients can still connect
+// without a certificate. The files are watched and reloaded when they change.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	CAFile   string `json:"ca_file"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != ""
+}
+
+// mutual reports whether nodes authenticate each other with certificates.
+func (c TLSConfig) mutual() bool {
+	return c.enabled() && c.CAFile != ""
+}
+
+func (c TLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if c.CAFile != "" && c.CertFile == "" {
+		return fmt.Errorf("ca_file needs cert_file and key_file")
+	}
+	return nil
+}
+
+// tlsReloadInterval is how often the certificate files are checked for
+// changes.
+const tlsReloadInterval = 5 * time.Second
+
+// tlsMaterial is the certificate and CA pool in use. version changes with
+// every reload.
+type tlsMaterial struct {
+	cert    *tls.Certificate
+	pool    *x509.CertPool // nil without ca_file: the system roots are used
+	version uint64
+}
+
+var tlsFiles struct {
+	mu       sync.Mutex
+	current  tlsMaterial
+	modTimes []time.Time
+	checked  time.Time
+}
+
+func tlsFileModTimes() []time.Time {
+	var times []time.Time
+	for _, name := range []string{cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CAFile} {
+		var mod time.Time
+		if name != "" {
+			if info, err := os.Stat(name); err == nil {
+				mod = info.ModTime()
+			}
+		}
+		times = append(times, mod)
+	}
+	return times
+}
+
+func readTLSMaterial() (tlsMaterial, error) {
+	var m tlsMaterial
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return m, fmt.Errorf("loading certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return m, fmt.Errorf("parsing certificate: %w", err)
+		}
+	}
+	m.cert = &cert
+	if cfg.TLS.CAFile != "" {
+		data, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return m, fmt.Errorf("reading CA: %w", err)
+		}
+		m.pool = x509.NewCertPool()
+		if !m.pool.AppendCertsFromPEM(data) {
+			return m, fmt.Errorf("no certificates found in %s", cfg.TLS.CAFile)
+		}
+	}
+	return m, nil
+}
+
+// loadTLS reads the certificate files at startup.
+func loadTLS() error {
+	if !cfg.TLS.enabled() {
+		return nil
+	}
+	m, err := readTLSMaterial()
+	if err != nil {
+		return err
+	}
+	m.version = 1
+	tlsFiles.mu.Lock()
+	defer tlsFiles.mu.Unlock()
+	tlsFiles.current = m
+	tlsFiles.modTimes = tlsFileModTimes()
+	tlsFiles.checked = time.Now()
+	return nil
+}
+
+// currentTLS returns the certificate and CA pool to use, reloading them if
+// the files changed. A reload that fails keeps the previous ones.
+func currentTLS() tlsMaterial {
+	tlsFiles.mu.Lock()
+	defer tlsFiles.mu.Unlock()
+	if time.Since(tlsFiles.checked) < tlsReloadInterval {
+		return tlsFiles.current
+	}
+	tlsFiles.checked = time.Now()
+
+	times := tlsFileModTimes()
+	changed := false
+	for i := range times {
+		if !times[i].Equal(tlsFiles.modTimes[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return tlsFiles.current
+	}
+	m, err := readTLSMaterial()
+	if err != nil {
+		// The files may be in the middle of being replaced; try again later.
+		slog.Error("Failed to reload TLS certificates, keeping the current ones", "error", err)
+		return tlsFiles.current
+	}
+	m.version = tlsFiles.current.version + 1
+	tlsFiles.current = m
+	tlsFiles.modTimes = times
+	slog.Info("Reloaded TLS certificates", "cert", cfg.TLS.CertFile, "expires", m.cert.Leaf.NotAfter)
+	return m
+}
+
+// serverTLSConfig is the listener's TLS configuration. Every handshake picks
+// up the current certificate and CA.
+func serverTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return currentTLS().cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			m := currentTLS()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*m.cert},
+			}
+			if m.pool != nil {
+				c.ClientCAs = m.pool
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return c, nil
+		},
+	}
+}
+
+// verifiedPeer reports whether the caller presented a certificate signed by
+// the cluster CA.
+func verifiedPeer(r *http.Request) bool {
+	return cfg.TLS.mutual() && r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// transports holds the HTTP transports for requests to other nodes, rebuilt
+// when the certificates change. node presents this node's certificate;
+// plain only trusts the CA and is used to pass on client requests.
+var transports struct {
+	mu      sync.Mutex
+	version uint64
+	node    *http.Transport
+	plain   *http.Transport
+}
+
+func currentTransports() (node, plain http.RoundTripper) {
+	if !cfg.TLS.enabled() {
+		return http.DefaultTransport, http.DefaultTransport
+	}
+	m := currentTLS()
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	if transports.node == nil || transports.version != m.version {
+		if transports.node != nil {
+			transports.node.CloseIdleConnections()
+			transports.plain.CloseIdleConnections()
+		}
+		transports.node = http.DefaultTransport.(*http.Transport).Clone()
+		transports.node.TLSClientConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			RootCAs:      m.pool,
+			Certificates: []tls.Certificate{*m.cert},
+		}
+		transports.plain = http.DefaultTransport.(*http.Transport).Clone()
+		transports.plain.TLSClientConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    m.pool,
+		}
+		transports.version = m.version
+	}
+	return transports.node, transports.plain
+}
+
+// clientTransport passes requests of clients on to another node, for
+// example a read forwarded to the master. It carries no node identity.
+type clientTransport struct{}
+
+func (clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, plain := currentTransports()
+	return plain.RoundTrip(req)
+}
+
+// listenAndServe serves h on the configured address, over HTTPS when TLS is
+// configured.
+func listenAndServe(h http.Handler) error {
+	server := &http.Server{Addr: cfg.Listen, Handler: h}
+	if !cfg.TLS.enabled() {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = serverTLSConfig()
+	return server.ListenAndServeTLS("", "")
+}
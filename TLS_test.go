@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of a test cluster.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a node certificate with the given serial number and its key
+// to certFile and keyFile, dated mod so the change is seen.
+func (ca *testCA) issue(t *testing.T, serial int64, certFile, keyFile string, mod time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "node"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), mod)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), mod)
+}
+
+func writeFile(t *testing.T, name string, data []byte, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withTLS configures TLS with a certificate of serial 1 and loads it.
+func withTLS(t *testing.T) (*testCA, string, string) {
+	t.Helper()
+	saved := cfg
+	t.Cleanup(func() {
+		cfg = saved
+		tlsFiles.current, tlsFiles.modTimes, tlsFiles.checked = tlsMaterial{}, nil, time.Time{}
+		transports.node, transports.plain, transports.version = nil, nil, 0
+	})
+	dir := t.TempDir()
+	cfg.TLS = TLSConfig{
+		CertFile: filepath.Join(dir, "node.crt"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+	ca := newTestCA(t)
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, cfg.TLS.CAFile, ca.pem, start)
+	ca.issue(t, 1, cfg.TLS.CertFile, cfg.TLS.KeyFile, start)
+	if err := loadTLS(); err != nil {
+		t.Fatal(err)
+	}
+	return ca, cfg.TLS.CertFile, cfg.TLS.KeyFile
+}
+
+// recheckTLS makes the next currentTLS look at the files again.
+func recheckTLS() {
+	tlsFiles.mu.Lock()
+	tlsFiles.checked = time.Time{}
+	tlsFiles.mu.Unlock()
+}
+
+func TestCurrentTLSReloads(t *testing.T) {
+	ca, certFile, keyFile := withTLS(t)
+
+	m := currentTLS()
+	if m.version != 1 || m.cert.Leaf.SerialNumber.Int64() != 1 || m.pool == nil {
+		t.Fatalf("loaded version %d serial %v", m.version, m.cert.Leaf.SerialNumber)
+	}
+
+	// A new certificate is not looked for before the interval is over.
+	ca.issue(t, 2, certFile, keyFile, time.Now())
+	if m := currentTLS(); m.version != 1 {
+		t.Errorf("version %d before the reload interval, want 1", m.version)
+	}
+
+	recheckTLS()
+	m = currentTLS()
+	if m.version != 2 || m.cert.Leaf.SerialNumber.Int64() != 2 {
+		t.Errorf("after the change version %d serial %v, want 2 and 2", m.version, m.cert.Leaf.SerialNumber)
+	}
+
+	// Unchanged files are not read again.
+	recheckTLS()
+	if m := currentTLS(); m.version != 2 {
+		t.Errorf("unchanged files: version %d, want 2", m.version)
+	}
+
+	// A half-written certificate keeps the previous one until it is fixed.
+	writeFile(t, certFile, []byte("-----BEGIN CERTIFICATE-----\n"), time.Now().Add(time.Second))
+	recheckTLS()
+	if m := currentTLS(); m.version != 2 || m.cert.Leaf.SerialNumber.Int64() != 2 {
+		t.Errorf("broken file: version %d serial %v, want the previous certificate", m.version, m.cert.Leaf.SerialNumber)
+	}
+	ca.issue(t, 3, certFile, keyFile, time.Now().Add(2*time.Second))
+	recheckTLS()
+	if m := currentTLS(); m.version != 3 || m.cert.Leaf.SerialNumber.Int64() != 3 {
+		t.Errorf("fixed file: version %d serial %v, want 3 and 3", m.version, m.cert.Leaf.SerialNumber)
+	}
+}
+
+func TestCurrentTransportsRebuiltOnReload(t *testing.T) {
+	ca, certFile, keyFile := withTLS(t)
+
+	node, plain := currentTransports()
+	if again, _ := currentTransports(); again != node {
+		t.Error("transports rebuilt without a reload")
+	}
+	if certs := node.(*http.Transport).TLSClientConfig.Certificates; len(certs) != 1 {
+		t.Errorf("node transport presents %d certificates, want 1", len(certs))
+	}
+	if certs := plain.(*http.Transport).TLSClientConfig.Certificates; len(certs) != 0 {
+		t.Errorf("plain transport presents %d certificates, want none", len(certs))
+	}
+
+	ca.issue(t, 2, certFile, keyFile, time.Now())
+	recheckTLS()
+	newNode, newPlain := currentTransports()
+	if newNode == node || newPlain == plain {
+		t.Error("transports not rebuilt after the certificates changed")
+	}
+	leaf, err := x509.ParseCertificate(newNode.(*http.Transport).TLSClientConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Int64() != 2 {
+		t.Errorf("node transport presents serial %v, want 2", leaf.SerialNumber)
+	}
+}
+
+func TestServerPicksUpReloadedCertificate(t *testing.T) {
+	ca, certFile, keyFile := withTLS(t)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	server.TLS = serverTLSConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serial := func() int64 {
+		t.Helper()
+		// A fresh transport for every request, so each one makes a handshake.
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "localhost"}}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 1 {
+		t.Errorf("server presented serial %d, want 1", got)
+	}
+	ca.issue(t, 2, certFile, keyFile, time.Now())
+	recheckTLS()
+	if got := serial(); got != 2 {
+		t.Errorf("after the reload the server presented serial %d, want 2", got)
+	}
+}
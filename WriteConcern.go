@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteConcern says how many slaves must have applied a write before the
+// master answers the client.
+//
+//	async      answer as soon as the master committed (the default)
+//	semi-sync  wait until at least Acks slaves acknowledged the write
+//	sync       wait until every online slave that replicates the table
+//	           acknowledged the write
+type WriteConcern struct {
+	Level     string `json:"level"`
+	Acks      int    `json:"acks,omitempty"`
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+}
+
+const (
+	concernAsync    = "async"
+	concernSemiSync = "semi-sync"
+	concernSync     = "sync"
+)
+
+const defaultConcernTimeout = 5 * time.Second
+
+// normalize fills in defaults and rejects unknown levels.
+func (wc WriteConcern) normalize() (WriteConcern, error) {
+	wc.Level = strings.ToLower(strings.TrimSpace(wc.Level))
+	switch wc.Level {
+	case "", concernAsync:
+		wc.Level = concernAsync
+	case concernSemiSync, "semisync", "semi_sync":
+		wc.Level = concernSemiSync
+		if wc.Acks <= 0 {
+			wc.Acks = 1
+		}
+	case concernSync:
+	default:
+		return wc, invalidf("unknown write concern level %q", wc.Level)
+	}
+	if wc.TimeoutMS < 0 {
+		return wc, invalidf("write concern timeout must not be negative")
+	}
+	return wc, nil
+}
+
+func (wc WriteConcern) timeout() time.Duration {
+	if wc.TimeoutMS > 0 {
+		return time.Duration(wc.TimeoutMS) * time.Millisecond
+	}
+	return defaultConcernTimeout
+}
+
+func (wc WriteConcern) rank() int {
+	switch wc.Level {
+	case concernSync:
+		return 2
+	case concernSemiSync:
+		return 1
+	}
+	return 0
+}
+
+// stricter returns whichever of a and b protects a write better.
+func stricter(a, b WriteConcern) WriteConcern {
+	if b.rank() > a.rank() || (b.rank() == a.rank() && b.Acks > a.Acks) {
+		if b.TimeoutMS == 0 {
+			b.TimeoutMS = a.TimeoutMS
+		}
+		return b
+	}
+	return a
+}
+
+// effectiveConcern combines the cluster default, the per-table setting and the
+// concern requested by the client. The request replaces the cluster default,
+// but a table's configured concern is a minimum a request cannot lower.
+func effectiveConcern(task ReplicationTask, requested *WriteConcern) (WriteConcern, error) {
+	wc := cfg.WriteConcern
+	if requested != nil {
+		wc = *requested
+	}
+	wc, err := wc.normalize()
+	if err != nil {
+		return wc, err
+	}
+
+	// A transaction gets the strictest concern of the tables it writes to.
+	if task.Operation == "transaction" {
+		for _, op := range task.Operations {
+			if wc, err = effectiveConcern(op, &wc); err != nil {
+				return wc, err
+			}
+		}
+		return wc, nil
+	}
+
+	for _, key := range []string{task.DBName + "." + task.Table, task.DBName} {
+		if tableConcern, ok := cfg.TableWriteConcerns[key]; ok {
+			tableConcern, err := tableConcern.normalize()
+			if err != nil {
+				return wc, err
+			}
+			return stricter(wc, tableConcern), nil
+		}
+	}
+	return wc, nil
+}
+
+// errConcernNotMet is returned when a write was committed on the master but
+// not enough slaves acknowledged it in time.
+type errConcernNotMet struct {
+	Concern  WriteConcern
+	Acks     int
+	Required int
+}
+
+func (e *errConcernNotMet) Error() string {
+	return fmt.Sprintf("write committed on master but %s write concern not met: %d of %d slave acknowledgements within %v",
+		e.Concern.Level, e.Acks, e.Required, e.Concern.timeout())
+}
+
+var (
+	ackMu     sync.Mutex
+	ackNotify = make(chan struct{}) // closed and replaced whenever a slave acknowledges
+)
+
+// notifyAck wakes everyone waiting for acknowledgements.
+func notifyAck() {
+	ackMu.Lock()
+	close(ackNotify)
+	ackNotify = make(chan struct{})
+	ackMu.Unlock()
+}
+
+func ackChanged() <-chan struct{} {
+	ackMu.Lock()
+	defer ackMu.Unlock()
+	return ackNotify
+}
+
+// countAcks returns how many slaves have applied entry and how many
+// acknowledgements the concern requires right now. A slave whose replication
+// filter leaves the entry out acknowledges it as a skip and holds no copy, so
+// it neither counts towards the acknowledgements nor is waited for.
+func countAcks(entry LogEntry, wc WriteConcern) (acks, required int) {
+	online := 0
+	rangeSlaves(func(s *slaveState) {
+		if s.filter().filter(entry.Task).Operation == "skip" {
+			return
+		}
+		if s.isOnline() {
+			online++
+		}
+		if s.acked() >= entry.LSN {
+			acks++
+		}
+	})
+
+	switch wc.Level {
+	case concernSemiSync:
+		required = wc.Acks
+	case concernSync:
+		// Every online slave that takes the write, and at least one: a
+		// sync write with no slave to copy it to would not be protected
+		// at all.
+		required = online
+		if required == 0 {
+			required = 1
+		}
+	}
+	return acks, required
+}
+
+// waitForConcern blocks until entry satisfies wc or the concern's
+// timeout expires. It returns the number of acknowledgements seen.
+func waitForConcern(entry LogEntry, wc WriteConcern) (int, error) {
+	if wc.Level == concernAsync {
+		return 0, nil
+	}
+
+	deadline := time.NewTimer(wc.timeout())
+	defer deadline.Stop()
+	for {
+		changed := ackChanged()
+		acks, required := countAcks(entry, wc)
+		if acks >= required {
+			return acks, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			countConcernTimeout()
+			return acks, &errConcernNotMet{Concern: wc, Acks: acks, Required: required}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import "testing"
+
+// withSlaves registers slaves with the master for the duration of a test,
+// without starting their senders.
+func withSlaves(t *testing.T, slaves ...*slaveState) {
+	t.Helper()
+	for _, s := range slaves {
+		slaveConnections.Store(s.addr, s)
+	}
+	t.Cleanup(func() {
+		for _, s := range slaves {
+			slaveConnections.Delete(s.addr)
+		}
+	})
+}
+
+func TestCountAcks(t *testing.T) {
+	slave := func(addr string, online bool, acked uint64) *slaveState {
+		return &slaveState{addr: addr, online: online, ackedLSN: acked}
+	}
+	noShop := ReplicationFilter{Exclude: []string{"shop"}}
+	insert := LogEntry{LSN: 10, Task: ReplicationTask{Operation: "insert", DBName: "shop", Table: "orders"}}
+
+	tests := []struct {
+		name     string
+		slaves   []*slaveState
+		wc       WriteConcern
+		acks     int
+		required int
+	}{
+		{"async", []*slaveState{slave("a", true, 10)}, WriteConcern{Level: concernAsync}, 1, 0},
+		{"semi-sync met", []*slaveState{slave("a", true, 10), slave("b", true, 9)}, WriteConcern{Level: concernSemiSync, Acks: 1}, 1, 1},
+		{"semi-sync short", []*slaveState{slave("a", true, 10), slave("b", true, 9)}, WriteConcern{Level: concernSemiSync, Acks: 2}, 1, 2},
+		{"semi-sync counts offline acks", []*slaveState{slave("a", false, 12)}, WriteConcern{Level: concernSemiSync, Acks: 1}, 1, 1},
+		{"sync waits for online slaves", []*slaveState{slave("a", true, 10), slave("b", true, 9), slave("c", false, 0)}, WriteConcern{Level: concernSync}, 1, 2},
+		{"sync without online slaves", []*slaveState{slave("a", false, 0)}, WriteConcern{Level: concernSync}, 0, 1},
+		{"sync without slaves", nil, WriteConcern{Level: concernSync}, 0, 1},
+		{
+			"filtered slave is not counted",
+			[]*slaveState{slave("a", true, 10), {addr: "b", online: true, ackedLSN: 10, replFilter: noShop}},
+			WriteConcern{Level: concernSemiSync, Acks: 2}, 1, 2,
+		},
+		{
+			"sync does not wait for a filtered slave",
+			[]*slaveState{slave("a", true, 10), {addr: "b", online: true, replFilter: noShop}},
+			WriteConcern{Level: concernSync}, 1, 1,
+		},
+		{
+			"sync with only filtered slaves",
+			[]*slaveState{{addr: "b", online: true, ackedLSN: 10, replFilter: noShop}},
+			WriteConcern{Level: concernSync}, 0, 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withSlaves(t, tt.slaves...)
+			acks, required := countAcks(insert, tt.wc)
+			if acks != tt.acks || required != tt.required {
+				t.Errorf("countAcks = %d of %d, want %d of %d", acks, required, tt.acks, tt.required)
+			}
+		})
+	}
+}
+
+func TestEffectiveConcern(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.WriteConcern = WriteConcern{Level: concernSemiSync, Acks: 1}
+	cfg.TableWriteConcerns = map[string]WriteConcern{
+		"shop.orders": {Level: concernSync, TimeoutMS: 2000},
+		"shop":        {Level: concernSemiSync, Acks: 2},
+		"crm.notes":   {Level: concernAsync},
+	}
+	insert := func(db, table string) ReplicationTask {
+		return ReplicationTask{Operation: "insert", DBName: db, Table: table}
+	}
+
+	tests := []struct {
+		name      string
+		task      ReplicationTask
+		requested *WriteConcern
+		want      WriteConcern
+		err       bool
+	}{
+		{"cluster default", insert("crm", "users"), nil, WriteConcern{Level: concernSemiSync, Acks: 1}, false},
+		{"request replaces the default", insert("crm", "users"), &WriteConcern{Level: "async"}, WriteConcern{Level: concernAsync}, false},
+		{"table minimum", insert("shop", "orders"), &WriteConcern{Level: "async"}, WriteConcern{Level: concernSync, TimeoutMS: 2000}, false},
+		{"table entry wins over database", insert("shop", "orders"), &WriteConcern{Level: "semi-sync", Acks: 3}, WriteConcern{Level: concernSync, TimeoutMS: 2000}, false},
+		{"database minimum", insert("shop", "users"), &WriteConcern{Level: "semi-sync"}, WriteConcern{Level: concernSemiSync, Acks: 2}, false},
+		{"request above the minimum", insert("shop", "users"), &WriteConcern{Level: "sync"}, WriteConcern{Level: concernSync}, false},
+		{"async table does not lower", insert("crm", "notes"), nil, WriteConcern{Level: concernSemiSync, Acks: 1}, false},
+		{"transaction takes the strictest", ReplicationTask{Operation: "transaction", Operations: []ReplicationTask{
+			insert("crm", "users"), insert("shop", "orders"),
+		}}, nil, WriteConcern{Level: concernSync, TimeoutMS: 2000}, false},
+		{"unknown level", insert("crm", "users"), &WriteConcern{Level: "quorum"}, WriteConcern{}, true},
+		{"negative timeout", insert("crm", "users"), &WriteConcern{Level: "sync", TimeoutMS: -1}, WriteConcern{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := effectiveConcern(tt.task, tt.requested)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("effectiveConcern = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStricter(t *testing.T) {
+	async := WriteConcern{Level: concernAsync}
+	semi1 := WriteConcern{Level: concernSemiSync, Acks: 1, TimeoutMS: 100}
+	semi2 := WriteConcern{Level: concernSemiSync, Acks: 2}
+	sync := WriteConcern{Level: concernSync, TimeoutMS: 300}
+
+	tests := []struct {
+		a, b WriteConcern
+		want WriteConcern
+	}{
+		{async, semi1, semi1},
+		{semi1, async, semi1},
+		{semi1, semi2, WriteConcern{Level: concernSemiSync, Acks: 2, TimeoutMS: 100}},
+		{semi2, semi1, semi2},
+		{semi2, sync, sync},
+		{sync, semi2, sync},
+		{sync, sync, sync},
+	}
+	for _, tt := range tests {
+		if got := stricter(tt.a, tt.b); got != tt.want {
+			t.Errorf("stricter(%+v, %+v) = %+v, want %+v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
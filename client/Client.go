@@ -0,0 +1,582 @@
+// Package client is a Go client for the distributed database cluster.
+//
+// A Client is created from the addresses of one or more nodes. It asks them
+// for the cluster's state on /status, sends writes to the master and spreads
+// reads over the healthy slaves. When the master changes it finds the new one
+// and retries, and every error the server returns is a *Error carrying the
+// server's error code.
+//
+//	c := client.New("http://localhost:8083", "http://localhost:8084")
+//	_, err := c.Insert(ctx, "shop", "orders", map[string]interface{}{"item": "pen", "qty": 3})
+//	rows, err := c.Select(ctx, client.Query{DBName: "shop", Table: "orders"})
+//
+// Reads use the token of the client's last write, so a Client always sees
+// its own writes, even when the read is served by a slave.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Column describes a column of a new table.
+type Column struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	NotNull       bool   `json:"not_null,omitempty"`
+	PrimaryKey    bool   `json:"primary_key,omitempty"`
+	AutoIncrement bool   `json:"auto_increment,omitempty"`
+	Unique        bool   `json:"unique,omitempty"`
+}
+
+// Predicate is one condition of a WHERE clause. A leaf compares Column with
+// Value (or Values for "in" and "not in") using Op; a group combines its And
+// or Or predicates. A list of predicates is combined with AND.
+type Predicate struct {
+	Column string        `json:"column,omitempty"`
+	Op     string        `json:"op,omitempty"`
+	Value  interface{}   `json:"value,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+	And    []Predicate   `json:"and,omitempty"`
+	Or     []Predicate   `json:"or,omitempty"`
+}
+
+// Eq returns the predicate column = value.
+func Eq(column string, value interface{}) Predicate {
+	return Predicate{Column: column, Op: "=", Value: value}
+}
+
+// Query is a read of one table.
+type Query struct {
+	DBName  string      `json:"dbname"`
+	Table   string      `json:"table"`
+	Columns []string    `json:"columns,omitempty"`
+	Where   []Predicate `json:"where,omitempty"`
+	Limit   int         `json:"limit,omitempty"`
+
+	// MaxStaleness, if set, only lets slaves answer that are at most this
+	// far behind the master.
+	MaxStaleness time.Duration `json:"-"`
+}
+
+// WriteConcern says how many slaves must have applied a write before the
+// master answers. Level is "async", "semi-sync" or "sync".
+type WriteConcern struct {
+	Level     string `json:"level"`
+	Acks      int    `json:"acks,omitempty"`
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+}
+
+// WriteResult describes a committed write.
+type WriteResult struct {
+	// LSN is the write's position in the replication log. It is also the
+	// consistency token reads use to see the write.
+	LSN uint64 `json:"lsn"`
+	// Acks is the number of slaves that had applied the write when the
+	// master answered.
+	Acks int `json:"acks"`
+}
+
+// Error is an error response from a node.
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"error"`
+	// Master is the master's address, set when a node answers that it is
+	// not the master.
+	Master string `json:"master"`
+	// Committed is set when the write was committed although the request
+	// failed, for example because its write concern was not met in time.
+	Committed bool   `json:"committed"`
+	LSN       uint64 `json:"lsn"`
+
+	// location is where a redirect response sends the request.
+	location string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+	}
+	return e.Message
+}
+
+// ErrNoMaster is returned when no node of the cluster is the master.
+var ErrNoMaster = errors.New("no master found in the cluster")
+
+// nodeStatus mirrors the /status response of a node.
+type nodeStatus struct {
+	Address     string   `json:"address"`
+	Role        string   `json:"role"`
+	Master      string   `json:"master"`
+	Term        uint64   `json:"term"`
+	LSN         uint64   `json:"lsn"`
+	Peers       []string `json:"peers"`
+	StalenessMS int64    `json:"staleness_ms"`
+	Filter      *filter  `json:"filter"`
+}
+
+// filter mirrors the replication filter of a slave that only holds some
+// databases or tables.
+type filter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// allows reports whether a slave with filter f holds dbname.table, matching
+// patterns the way the server does.
+func (f *filter) allows(dbname, table string) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.Exclude {
+		if matchPattern(p, dbname, table) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if matchPattern(p, dbname, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether the filter pattern p ("db" or "db.table",
+// with shell-style wildcards) matches dbname.table.
+func matchPattern(p, dbname, table string) bool {
+	dbPattern, tablePattern, hasTable := strings.Cut(p, ".")
+	if ok, _ := path.Match(dbPattern, dbname); !ok {
+		return false
+	}
+	if !hasTable {
+		return true
+	}
+	ok, _ := path.Match(tablePattern, table)
+	return ok
+}
+
+// Client talks to a cluster. It is safe for concurrent use.
+type Client struct {
+	// HTTPClient sends the requests; it defaults to a client with a 30 second
+	// timeout.
+	HTTPClient *http.Client
+	// Retries is how often a request is retried after a failover or an
+	// unreachable node. Only writes that were certainly not committed are
+	// retried.
+	Retries int
+	// RetryDelay is the wait before the first retry; it doubles with every
+	// further retry.
+	RetryDelay time.Duration
+	// WriteConcern, if set, is sent with every write.
+	WriteConcern *WriteConcern
+	// APIKey, if set, is sent with every request as a bearer token.
+	APIKey string
+
+	mu        sync.Mutex
+	nodes     []string // every node address the client knows about
+	master    string
+	slaves    []string
+	filters   map[string]*filter // filters of the slaves that have one
+	next      int                // round-robin position in slaves
+	lastWrite uint64             // LSN of the client's latest write
+	refreshed time.Time
+}
+
+// New returns a client for the cluster that nodes belong to. Any node will
+// do; the others are discovered from it.
+func New(nodes ...string) *Client {
+	c := &Client{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Retries:    5,
+		RetryDelay: 200 * time.Millisecond,
+	}
+	for _, node := range nodes {
+		c.addNode(node)
+	}
+	return c
+}
+
+func (c *Client) addNode(addr string) {
+	addr = strings.TrimSuffix(addr, "/")
+	if addr == "" {
+		return
+	}
+	for _, node := range c.nodes {
+		if node == addr {
+			return
+		}
+	}
+	c.nodes = append(c.nodes, addr)
+}
+
+// Refresh asks the known nodes for the state of the cluster and updates the
+// master and the list of healthy slaves. It is called automatically when
+// needed.
+func (c *Client) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	nodes := append([]string(nil), c.nodes...)
+	c.mu.Unlock()
+
+	var (
+		statuses []nodeStatus
+		lastErr  error
+	)
+	seen := make(map[string]bool)
+	for i := 0; i < len(nodes); i++ {
+		if seen[nodes[i]] {
+			continue
+		}
+		seen[nodes[i]] = true
+
+		status, err := c.status(ctx, nodes[i])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		statuses = append(statuses, status)
+		// Learn about nodes the seeds did not list.
+		for _, addr := range append(status.Peers, status.Master) {
+			if addr != "" && !seen[addr] {
+				nodes = append(nodes, addr)
+			}
+		}
+	}
+	if len(statuses) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no nodes configured")
+		}
+		return fmt.Errorf("no node of the cluster is reachable: %w", lastErr)
+	}
+
+	// The master of the highest term wins; an old master that has not
+	// noticed its replacement yet is ignored.
+	var master nodeStatus
+	for _, s := range statuses {
+		if s.Role == "master" && s.Term >= master.Term {
+			master = s
+		}
+	}
+	var slaves []string
+	filters := make(map[string]*filter)
+	for _, s := range statuses {
+		if s.Role == "slave" && s.Master != "" && s.Master == master.Address {
+			slaves = append(slaves, s.Address)
+			if s.Filter != nil {
+				filters[s.Address] = s.Filter
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, addr := range nodes {
+		c.addNode(addr)
+	}
+	c.master = master.Address
+	c.slaves = slaves
+	c.filters = filters
+	c.refreshed = time.Now()
+	if c.master == "" {
+		return ErrNoMaster
+	}
+	return nil
+}
+
+func (c *Client) status(ctx context.Context, node string) (nodeStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	var status nodeStatus
+	err := c.do(ctx, http.MethodGet, node+"/status", nil, &status)
+	return status, err
+}
+
+// Master returns the address of the current master.
+func (c *Client) Master(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	master := c.master
+	c.mu.Unlock()
+	if master != "" {
+		return master, nil
+	}
+	if err := c.Refresh(ctx); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.master, nil
+}
+
+// CreateDatabase creates a database.
+func (c *Client) CreateDatabase(ctx context.Context, name string) (WriteResult, error) {
+	return c.write(ctx, "/createdb", map[string]interface{}{"dbname": name})
+}
+
+// DropDatabase drops a database.
+func (c *Client) DropDatabase(ctx context.Context, name string) (WriteResult, error) {
+	return c.write(ctx, "/dropdb", map[string]interface{}{"dbname": name})
+}
+
+// CreateTable creates a table with the given columns.
+func (c *Client) CreateTable(ctx context.Context, dbname, table string, columns []Column) (WriteResult, error) {
+	return c.write(ctx, "/createtable", map[string]interface{}{"dbname": dbname, "table": table, "columns": columns})
+}
+
+// Insert inserts one row.
+func (c *Client) Insert(ctx context.Context, dbname, table string, values map[string]interface{}) (WriteResult, error) {
+	return c.write(ctx, "/insert", map[string]interface{}{"dbname": dbname, "table": table, "values": values})
+}
+
+// Update sets columns of the rows matching where. The server refuses an
+// update without conditions.
+func (c *Client) Update(ctx context.Context, dbname, table string, set map[string]interface{}, where ...Predicate) (WriteResult, error) {
+	return c.write(ctx, "/update", map[string]interface{}{"dbname": dbname, "table": table, "set": set, "where": where})
+}
+
+// Delete deletes the rows matching where. The server refuses a delete without
+// conditions.
+func (c *Client) Delete(ctx context.Context, dbname, table string, where ...Predicate) (WriteResult, error) {
+	return c.write(ctx, "/delete", map[string]interface{}{"dbname": dbname, "table": table, "where": where})
+}
+
+// BulkInsert inserts many rows in one transaction, replicated as a single
+// unit. Every row must have the same columns.
+func (c *Client) BulkInsert(ctx context.Context, dbname, table string, rows []map[string]interface{}) (WriteResult, error) {
+	return c.write(ctx, "/bulkinsert", map[string]interface{}{"dbname": dbname, "table": table, "rows": rows})
+}
+
+// Operation is one insert, update or delete of a transaction. Use
+// InsertOp, UpdateOp and DeleteOp to build them.
+type Operation struct {
+	Operation string                 `json:"operation"`
+	DBName    string                 `json:"dbname"`
+	Table     string                 `json:"table"`
+	Values    map[string]interface{} `json:"values,omitempty"`
+	Set       map[string]interface{} `json:"set,omitempty"`
+	Where     []Predicate            `json:"where,omitempty"`
+}
+
+// InsertOp is an insert of one row.
+func InsertOp(dbname, table string, values map[string]interface{}) Operation {
+	return Operation{Operation: "insert", DBName: dbname, Table: table, Values: values}
+}
+
+// UpdateOp sets columns of the rows matching where.
+func UpdateOp(dbname, table string, set map[string]interface{}, where ...Predicate) Operation {
+	return Operation{Operation: "update", DBName: dbname, Table: table, Set: set, Where: where}
+}
+
+// DeleteOp deletes the rows matching where.
+func DeleteOp(dbname, table string, where ...Predicate) Operation {
+	return Operation{Operation: "delete", DBName: dbname, Table: table, Where: where}
+}
+
+// Transaction applies ops atomically: either all of them are committed, and
+// replicated as one unit, or none.
+func (c *Client) Transaction(ctx context.Context, ops ...Operation) (WriteResult, error) {
+	return c.write(ctx, "/transaction", map[string]interface{}{"operations": ops})
+}
+
+// Select reads rows from a healthy slave that holds the table, or from the
+// master when there is none. The read sees every write this client made.
+func (c *Client) Select(ctx context.Context, q Query) ([]map[string]interface{}, error) {
+	params := url.Values{}
+	c.mu.Lock()
+	if c.lastWrite > 0 {
+		params.Set("token", strconv.FormatUint(c.lastWrite, 10))
+	}
+	c.mu.Unlock()
+	if q.MaxStaleness > 0 {
+		params.Set("max_staleness", strconv.FormatFloat(q.MaxStaleness.Seconds(), 'f', -1, 64))
+	}
+
+	var rows []map[string]interface{}
+	err := c.retry(ctx, func() (bool, error) {
+		node, err := c.readNode(ctx, q.DBName, q.Table)
+		if err != nil {
+			return true, err
+		}
+		err = c.do(ctx, http.MethodPost, node+"/select?"+params.Encode(), q, &rows)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.location != "" {
+			// A slave that has not applied our last write yet sends us to the
+			// master. Following the redirect here keeps the API key, which
+			// net/http drops when a redirect leaves the host.
+			err = c.do(ctx, http.MethodPost, apiErr.location, q, &rows)
+		}
+		// Any failed read can safely go to another node.
+		return err != nil && !isClientError(err), err
+	})
+	return rows, err
+}
+
+func (c *Client) write(ctx context.Context, path string, body map[string]interface{}) (WriteResult, error) {
+	if c.WriteConcern != nil {
+		body["write_concern"] = c.WriteConcern
+	}
+
+	var result WriteResult
+	err := c.retry(ctx, func() (bool, error) {
+		master, err := c.Master(ctx)
+		if err != nil {
+			return true, err
+		}
+		err = c.do(ctx, http.MethodPost, master+path, body, &result)
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Committed {
+			c.noteWrite(apiErr.LSN)
+		}
+		return retryableWrite(err), err
+	})
+	if err == nil {
+		c.noteWrite(result.LSN)
+	}
+	return result, err
+}
+
+func (c *Client) noteWrite(lsn uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lsn > c.lastWrite {
+		c.lastWrite = lsn
+	}
+}
+
+// readNode picks the next healthy slave whose replication filter keeps
+// dbname.table, or the master if there is none.
+func (c *Client) readNode(ctx context.Context, dbname, table string) (string, error) {
+	c.mu.Lock()
+	stale := c.master == "" || time.Since(c.refreshed) > 30*time.Second
+	c.mu.Unlock()
+	if stale {
+		if err := c.Refresh(ctx); err != nil && err != ErrNoMaster {
+			return "", err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for range c.slaves {
+		c.next = (c.next + 1) % len(c.slaves)
+		if slave := c.slaves[c.next]; c.filters[slave].allows(dbname, table) {
+			return slave, nil
+		}
+	}
+	if c.master == "" {
+		return "", ErrNoMaster
+	}
+	return c.master, nil
+}
+
+// retry runs attempt until it succeeds, fails for good or runs out of
+// retries. Before every retry the client forgets the master and refreshes its
+// view of the cluster.
+func (c *Client) retry(ctx context.Context, attempt func() (retry bool, err error)) error {
+	delay := c.RetryDelay
+	for i := 0; ; i++ {
+		again, err := attempt()
+		if err == nil || !again || i >= c.Retries {
+			return err
+		}
+
+		var apiErr *Error
+		c.mu.Lock()
+		c.master = ""
+		if errors.As(err, &apiErr) && apiErr.Master != "" {
+			c.addNode(apiErr.Master)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+		c.Refresh(ctx)
+	}
+}
+
+// retryableWrite reports whether a failed write was certainly not committed
+// and may be sent again: the node was not the master (anymore), could not
+// write for lack of a quorum, or could not be reached at all.
+func retryableWrite(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == "not_master" || apiErr.Code == "no_quorum"
+	}
+	var opErr *net.OpError
+	return errors.Is(err, ErrNoMaster) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+func isClientError(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
+// do sends a request and decodes a successful JSON response into out. Error
+// responses, and redirects, become *Error.
+func (c *Client) do(ctx context.Context, method, target string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	client := *c.HTTPClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(resp.Status + ": " + string(data))
+		}
+		if loc, err := resp.Location(); err == nil && resp.StatusCode/100 == 3 {
+			apiErr.location = loc.String()
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(out)
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNode is a node of a fake cluster. Its /status answer can be changed
+// while the test runs, and it records the requests it served.
+type fakeNode struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   nodeStatus
+	handle   func(w http.ResponseWriter, r *http.Request)
+	requests []string
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	n := &fakeNode{}
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.mu.Lock()
+		status, handle := n.status, n.handle
+		if r.URL.Path != "/status" {
+			n.requests = append(n.requests, r.URL.Path)
+		}
+		n.mu.Unlock()
+		if r.URL.Path == "/status" {
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+		handle(w, r)
+	}))
+	t.Cleanup(n.Close)
+	n.status.Address = n.URL
+	n.handle = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"lsn":1}`)) }
+	return n
+}
+
+func (n *fakeNode) set(role, master string, term uint64, peers ...*fakeNode) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.status.Role, n.status.Master, n.status.Term = role, master, term
+	n.status.Peers = nil
+	for _, p := range peers {
+		n.status.Peers = append(n.status.Peers, p.URL)
+	}
+}
+
+func (n *fakeNode) served() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.requests...)
+}
+
+func testClient(nodes ...string) *Client {
+	c := New(nodes...)
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func TestRefresh(t *testing.T) {
+	master, slave1, slave2, oldMaster := newFakeNode(t), newFakeNode(t), newFakeNode(t), newFakeNode(t)
+	master.set("master", master.URL, 5, slave1, slave2, oldMaster)
+	slave1.set("slave", master.URL, 5, master)
+	slave2.set("slave", "", 5, master) // has not found the master yet
+	oldMaster.set("master", oldMaster.URL, 4)
+
+	// Only one seed; the rest is discovered from its peers.
+	c := testClient(slave1.URL + "/")
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.master != master.URL {
+		t.Errorf("master = %q, want %q", c.master, master.URL)
+	}
+	if !reflect.DeepEqual(c.slaves, []string{slave1.URL}) {
+		t.Errorf("slaves = %v, want only %s", c.slaves, slave1.URL)
+	}
+	if len(c.nodes) != 4 {
+		t.Errorf("nodes = %v, want all four", c.nodes)
+	}
+}
+
+func TestRefreshWithoutMaster(t *testing.T) {
+	slave := newFakeNode(t)
+	slave.set("slave", "", 3)
+	if err := testClient(slave.URL).Refresh(context.Background()); err != ErrNoMaster {
+		t.Errorf("err = %v, want ErrNoMaster", err)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	if err := testClient(down.URL).Refresh(context.Background()); err == nil || errors.Is(err, ErrNoMaster) {
+		t.Errorf("err = %v, want a connection error", err)
+	}
+}
+
+func TestSelectFollowsRedirect(t *testing.T) {
+	master, slave := newFakeNode(t), newFakeNode(t)
+	master.set("master", master.URL, 1, slave)
+	slave.set("slave", master.URL, 1)
+
+	var auth string
+	master.handle = func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte(`[{"id":1}]`))
+	}
+	slave.handle = func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", master.URL+r.URL.RequestURI())
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		w.Write([]byte(`{"message":"LSN 9 is not applied here yet, read from the master"}`))
+	}
+
+	c := testClient(master.URL)
+	c.APIKey = "r-key"
+	rows, err := c.Select(context.Background(), Query{DBName: "shop", Table: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || auth != "Bearer r-key" {
+		t.Errorf("rows = %v, master saw Authorization %q", rows, auth)
+	}
+	if got := slave.served(); len(got) != 1 {
+		t.Errorf("slave served %v, want one redirected read", got)
+	}
+}
+
+func TestWriteRetriesAfterFailover(t *testing.T) {
+	oldMaster, newMaster := newFakeNode(t), newFakeNode(t)
+	oldMaster.set("master", oldMaster.URL, 1, newMaster)
+	newMaster.set("slave", oldMaster.URL, 1, oldMaster)
+
+	// The old master loses its role after the client found it; the next
+	// refresh finds the new one.
+	oldMaster.handle = func(w http.ResponseWriter, r *http.Request) {
+		oldMaster.set("slave", newMaster.URL, 2, newMaster)
+		newMaster.set("master", newMaster.URL, 2, oldMaster)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"this node is not the master","code":"not_master"}`))
+	}
+	newMaster.handle = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"lsn":7,"acks":1}`)) }
+
+	c := testClient(oldMaster.URL)
+	result, err := c.Insert(context.Background(), "shop", "orders", map[string]interface{}{"item": "pen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.LSN != 7 || c.lastWrite != 7 {
+		t.Errorf("result = %+v, last write %d, want LSN 7", result, c.lastWrite)
+	}
+	if got := newMaster.served(); !reflect.DeepEqual(got, []string{"/insert"}) {
+		t.Errorf("new master served %v", got)
+	}
+}
+
+func TestWriteRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		attempts int
+		code     string
+	}{
+		{"not master", http.StatusServiceUnavailable, `{"error":"not master","code":"not_master"}`, 3, "not_master"},
+		{"no quorum", http.StatusServiceUnavailable, `{"error":"no quorum","code":"no_quorum"}`, 3, "no_quorum"},
+		{"invalid request", http.StatusBadRequest, `{"error":"Invalid query","code":"invalid"}`, 1, "invalid"},
+		{"committed", http.StatusGatewayTimeout, `{"error":"not met","code":"write_concern_not_met","committed":true,"lsn":4}`, 1, "write_concern_not_met"},
+		{"server error", http.StatusInternalServerError, `{"error":"Failed to insert"}`, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master := newFakeNode(t)
+			master.set("master", master.URL, 1)
+			master.handle = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}
+
+			c := testClient(master.URL)
+			c.Retries = 2
+			_, err := c.Insert(context.Background(), "shop", "orders", map[string]interface{}{"item": "pen"})
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.code || apiErr.StatusCode != tt.status {
+				t.Fatalf("err = %#v, want code %q", err, tt.code)
+			}
+			if got := len(master.served()); got != tt.attempts {
+				t.Errorf("sent %d times, want %d", got, tt.attempts)
+			}
+			if apiErr.Committed && c.lastWrite != apiErr.LSN {
+				t.Errorf("last write = %d, want the committed LSN %d", c.lastWrite, apiErr.LSN)
+			}
+		})
+	}
+}
+
+func TestSelectSkipsFilteredSlaves(t *testing.T) {
+	master, full, partial := newFakeNode(t), newFakeNode(t), newFakeNode(t)
+	master.set("master", master.URL, 1, full, partial)
+	full.set("slave", master.URL, 1)
+	partial.set("slave", master.URL, 1)
+	partial.status.Filter = &filter{Include: []string{"shop"}, Exclude: []string{"shop.sessions"}}
+	for _, n := range []*fakeNode{master, full, partial} {
+		n := n
+		n.handle = func(w http.ResponseWriter, r *http.Request) {
+			var q Query
+			json.NewDecoder(r.Body).Decode(&q)
+			if n == partial && !(q.DBName == "shop" && q.Table == "orders") {
+				t.Errorf("filtered slave got a read of %s.%s", q.DBName, q.Table)
+			}
+			w.Write([]byte("[]"))
+		}
+	}
+
+	c := testClient(master.URL)
+	for _, q := range []Query{
+		{DBName: "shop", Table: "orders"}, {DBName: "shop", Table: "sessions"}, {DBName: "crm", Table: "users"},
+		{DBName: "shop", Table: "orders"}, {DBName: "shop", Table: "sessions"}, {DBName: "crm", Table: "users"},
+	} {
+		if _, err := c.Select(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(partial.served()) == 0 || len(master.served()) != 0 {
+		t.Errorf("filtered slave served %d reads and the master %d, want some and none", len(partial.served()), len(master.served()))
+	}
+}
+
+func TestFilterAllows(t *testing.T) {
+	f := &filter{Include: []string{"shop", "crm.audit_*"}, Exclude: []string{"shop.sessions"}}
+	tests := []struct {
+		db, table string
+		want      bool
+	}{
+		{"shop", "orders", true},
+		{"shop", "sessions", false},
+		{"crm", "audit_log", true},
+		{"crm", "users", false},
+		{"tmp", "t", false},
+	}
+	for _, tt := range tests {
+		if got := f.allows(tt.db, tt.table); got != tt.want {
+			t.Errorf("allows(%s.%s) = %v, want %v", tt.db, tt.table, got, tt.want)
+		}
+	}
+	var none *filter
+	if !none.allows("tmp", "t") {
+		t.Error("a slave without a filter must hold every table")
+	}
+}